@@ -0,0 +1,169 @@
+/*
+Copyright 2024 Kevin Park
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/loadbalancer/maglev"
+	"github.com/krapie/l7/internal/loadbalancer/rendezvous"
+	"github.com/krapie/l7/internal/loadbalancer/ring_hash"
+)
+
+// compareHashCmd compares the key-affinity tables on the same key stream
+var compareHashCmd = &cobra.Command{
+	Use:   "compare-hash",
+	Short: "Compare key distribution and disruption of the key-affinity algorithms",
+	Long: `Compare key distribution and disruption of the key-affinity algorithms.
+
+Every algorithm maps the same keys to the same backends. The distribution is the
+share of keys of the least and most loaded backend relative to a fair share, and
+the disruption is the share of keys which move when a backend is added or removed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backends, err := cmd.Flags().GetInt("backends")
+		if err != nil {
+			return err
+		}
+
+		keys, err := cmd.Flags().GetInt("keys")
+		if err != nil {
+			return err
+		}
+
+		return compareHash(backends, keys)
+	},
+}
+
+func compareHash(backends, keys int) error {
+	if backends < 2 || keys < 1 {
+		return fmt.Errorf("at least 2 backends and 1 key are required")
+	}
+
+	factories := []struct {
+		algorithm string
+		newTable  func() (registry.Table, error)
+	}{
+		{maglev.Algorithm, func() (registry.Table, error) { return maglev.NewMaglev(nil, maglev.MinVirtualNodes) }},
+		{ring_hash.Algorithm, func() (registry.Table, error) { return ring_hash.NewRing(ring_hash.DefaultReplicas) }},
+		{rendezvous.Algorithm, func() (registry.Table, error) { return rendezvous.NewRendezvous(), nil }},
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ALGORITHM\tMIN SHARE\tMAX SHARE\tMOVED ON ADD\tMOVED ON REMOVE\tLOOKUP\tUPDATE")
+	for _, factory := range factories {
+		table, err := factory.newTable()
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		for i := 0; i < backends; i++ {
+			if err := table.Add(fmt.Sprintf("backend-%d", i), 1); err != nil {
+				return err
+			}
+		}
+		update := time.Since(start) / time.Duration(backends)
+
+		start = time.Now()
+		before, err := mapKeys(table, keys)
+		if err != nil {
+			return err
+		}
+		lookup := time.Since(start) / time.Duration(keys)
+
+		counts := make(map[string]int)
+		for _, backend := range before {
+			counts[backend]++
+		}
+		minCount, maxCount := math.MaxInt, 0
+		for i := 0; i < backends; i++ {
+			count := counts[fmt.Sprintf("backend-%d", i)]
+			minCount = min(minCount, count)
+			maxCount = max(maxCount, count)
+		}
+		fair := float64(keys) / float64(backends)
+
+		if err := table.Add(fmt.Sprintf("backend-%d", backends), 1); err != nil {
+			return err
+		}
+		added, err := mapKeys(table, keys)
+		if err != nil {
+			return err
+		}
+
+		if err := table.Remove(fmt.Sprintf("backend-%d", backends)); err != nil {
+			return err
+		}
+		if err := table.Remove("backend-0"); err != nil {
+			return err
+		}
+		removed, err := mapKeys(table, keys)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "%s\t%.1f%%\t%.1f%%\t%.1f%%\t%.1f%%\t%s\t%s\n",
+			factory.algorithm,
+			float64(minCount)/fair*100,
+			float64(maxCount)/fair*100,
+			movedShare(before, added),
+			movedShare(before, removed),
+			lookup,
+			update,
+		)
+	}
+
+	return w.Flush()
+}
+
+func mapKeys(table registry.Table, keys int) ([]string, error) {
+	backends := make([]string, keys)
+	for i := range backends {
+		backend, err := table.Get(fmt.Sprintf("key-%d", i))
+		if err != nil {
+			return nil, err
+		}
+		backends[i] = backend
+	}
+
+	return backends, nil
+}
+
+func movedShare(before, after []string) float64 {
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			moved++
+		}
+	}
+
+	return float64(moved) / float64(len(before)) * 100
+}
+
+func init() {
+	rootCmd.AddCommand(compareHashCmd)
+
+	compareHashCmd.Flags().Int("backends", 5, "Number of backends")
+	compareHashCmd.Flags().Int("keys", 100000, "Number of keys")
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -27,6 +28,10 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/krapie/l7/internal"
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/loadbalancer"
+	"github.com/krapie/l7/internal/loadbalancer/hashkey"
+	"github.com/krapie/l7/internal/stream"
 )
 
 var cfgFile string
@@ -47,26 +52,61 @@ var rootCmd = &cobra.Command{
 }
 
 func runAgent(cmd *cobra.Command, args []string) error {
-	serviceDiscoveryMode, err := cmd.Flags().GetString("service-discovery-mode")
-	if err != nil {
+	config := &internal.Config{
+		ServiceDiscoveryMode: viper.GetString("service-discovery-mode"),
+		TargetFilter:         viper.GetString("target-filter"),
+		LBAlgorithm:          viper.GetString("lb-algorithm"),
+		MaglevHashKey:        viper.GetString("maglev-hash-key"),
+		HashKeySources:       viper.GetStringSlice("hash-key-sources"),
+		HashKeyFallback:      viper.GetString("hash-key-fallback"),
+		BoundedLoadEpsilon:   viper.GetFloat64("bounded-load-epsilon"),
+		AdminAddr:            viper.GetString("admin-addr"),
+		BackendProtocol:      viper.GetString("backend-protocol"),
+		StreamPaths:          viper.GetStringSlice("stream-paths"),
+		StreamMigration: stream.MigrationConfig{
+			Rate:        viper.GetFloat64("stream-migration-rate"),
+			GracePeriod: viper.GetDuration("stream-migration-grace-period"),
+		},
+		RetryMaxAttempts: viper.GetInt("retry-max-attempts"),
+		HTTPTimeouts: internal.ListenerTimeouts{
+			ReadTimeout:       viper.GetDuration("listener-read-timeout"),
+			WriteTimeout:      viper.GetDuration("listener-write-timeout"),
+			ReadHeaderTimeout: viper.GetDuration("listener-read-header-timeout"),
+			IdleTimeout:       viper.GetDuration("listener-idle-timeout"),
+		},
+	}
+	if err := viper.UnmarshalKey("pools", &config.Pools); err != nil {
 		return err
 	}
-
-	targetFilter, err := cmd.Flags().GetString("target-filter")
-	if err != nil {
+	if err := viper.UnmarshalKey("routes", &config.Routes); err != nil {
 		return err
 	}
-
-	maglevHashKey, err := cmd.Flags().GetString("maglev-hash-key")
-	if err != nil {
+	if err := viper.UnmarshalKey("virtual-hosts", &config.VirtualHosts); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("unknown-host", &config.UnknownHost); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("tls", &config.TLS); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("tls.timeouts", &config.HTTPSTimeouts); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("health-check", &config.HealthCheck); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("outlier-detection", &config.OutlierDetection); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("circuit-breaker", &config.CircuitBreaker); err != nil {
+		return err
+	}
+	if err := viper.UnmarshalKey("transport", &config.Transport); err != nil {
 		return err
 	}
 
-	agent, err := internal.NewAgent(&internal.Config{
-		ServiceDiscoveryMode: serviceDiscoveryMode,
-		TargetFilter:         targetFilter,
-		MaglevHashKey:        maglevHashKey,
-	})
+	agent, err := internal.NewAgent(config)
 	if err != nil {
 		return err
 	}
@@ -142,7 +182,24 @@ func init() {
 
 	rootCmd.Flags().String("service-discovery-mode", "docker", "Service discovery mode")
 	rootCmd.Flags().String("target-filter", "traefik/whoami", "Backend target filter for service discovery")
+	rootCmd.Flags().String("lb-algorithm", "maglev", fmt.Sprintf("Load balancing algorithm (%s)", strings.Join(loadbalancer.Algorithms(), ", ")))
 	rootCmd.Flags().String("maglev-hash-key", "X-Shard-Key", "Hash key for maglev consistent hashing")
+	rootCmd.Flags().StringSlice("hash-key-sources", nil, "Ordered hash key sources (header:<name>, cookie:<name>, query:<name>, path:<regex>, client-ip, yorkie-body), defaults to the maglev hash key header and yorkie-body")
+	rootCmd.Flags().String("hash-key-fallback", hashkey.FallbackRandom, "Policy for requests without hash key (random, reject, remote-addr)")
+	rootCmd.Flags().Float64("bounded-load-epsilon", 0, "Cap each backend at (1+epsilon) times the average load with key-affinity algorithms, disabled if zero")
+	rootCmd.Flags().String("backend-protocol", backend.ProtocolHTTP1, "Protocol of the requests to backends (http1, https, h2, h2c)")
+	rootCmd.Flags().StringSlice("stream-paths", []string{hashkey.YorkieServicePath + "WatchDocument"}, "Paths of long-lived streams tracked per backend, \"*\" for every request")
+	rootCmd.Flags().Float64("stream-migration-rate", 50, "Maximum number of streams moved to the new owner of their key per second after a rebalance, unlimited if zero")
+	rootCmd.Flags().Duration("stream-migration-grace-period", 5*time.Second, "Delay before moving streams after a rebalance, coalescing rebalances in quick succession")
+	rootCmd.Flags().Int("retry-max-attempts", loadbalancer.DefaultMaxAttempts, "Maximum number of attempts of a failed request on different backends, including the first one")
+	rootCmd.Flags().Duration("listener-read-timeout", 0, "Maximum duration of reading a request including its body, unlimited if zero")
+	rootCmd.Flags().Duration("listener-write-timeout", 0, "Maximum duration of writing a response, unlimited if zero")
+	rootCmd.Flags().Duration("listener-read-header-timeout", 10*time.Second, "Maximum duration of reading request headers, unlimited if zero")
+	rootCmd.Flags().Duration("listener-idle-timeout", 2*time.Minute, "Idle time after which keep-alive connections are closed, unlimited if zero")
+	rootCmd.Flags().String("admin-addr", "", "Address of the admin API server, disabled if empty (e.g. :9090)")
+
+	// Flags can also be set from the config file or environment variables.
+	cobra.CheckErr(viper.BindPFlags(rootCmd.Flags()))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -0,0 +1,147 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/loadbalancer"
+)
+
+// BackendInfo is the state of a backend exposed by the admin API.
+type BackendInfo struct {
+	Pool            string `json:"pool"`
+	ID              string `json:"id"`
+	Addr            string `json:"addr"`
+	Alive           bool   `json:"alive"`
+	Ejected         bool   `json:"ejected"`
+	Weight          int    `json:"weight"`
+	ActiveRequests  int64  `json:"activeRequests"`
+	PendingRequests int64  `json:"pendingRequests"`
+	Streams         int    `json:"streams"`
+	Latency         string `json:"latency"`
+}
+
+// Server serves the admin API, which exposes the backends of the pools and
+// allows operators to change backend weights at runtime.
+type Server struct {
+	pools      map[string]*loadbalancer.BackendPool
+	httpServer *http.Server
+}
+
+func NewServer(addr string, pools map[string]*loadbalancer.BackendPool) *Server {
+	s := &Server{
+		pools: pools,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backends", s.handleBackends)
+	mux.HandleFunc("/backends/weight", s.handleBackendWeight)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	s.httpServer = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	return s
+}
+
+func (s *Server) Start() {
+	go func() {
+		log.Printf("[Admin] Starting admin server on %s", s.httpServer.Addr)
+		if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[Admin] Server error: %v", err)
+		}
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// handleBackends lists the backends of every pool.
+//
+//	GET /backends
+func (s *Server) handleBackends(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var names []string
+	for name := range s.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	backends := []BackendInfo{}
+	for _, name := range names {
+		for _, b := range s.pools[name].Registry.GetBackends() {
+			backends = append(backends, BackendInfo{
+				Pool:            name,
+				ID:              b.ID,
+				Addr:            b.Addr.String(),
+				Alive:           b.IsAlive(),
+				Ejected:         b.IsEjected(),
+				Weight:          b.GetWeight(),
+				ActiveRequests:  b.ActiveRequests(),
+				PendingRequests: b.PendingRequests(),
+				Streams:         s.pools[name].Streams.Count(b.ID),
+				Latency:         b.Latency().String(),
+			})
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(backends); err != nil {
+		log.Printf("[Admin] Error encoding backends: %v", err)
+	}
+}
+
+// handleBackendWeight changes the weight of a backend.
+//
+//	PUT /backends/weight?pool=<pool name>&id=<backend ID>&weight=<weight>
+func (s *Server) handleBackendWeight(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut && req.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	name := query.Get("pool")
+	if name == "" {
+		name = loadbalancer.DefaultPool
+	}
+	pool, ok := s.pools[name]
+	if !ok {
+		http.Error(rw, "pool not found", http.StatusNotFound)
+		return
+	}
+
+	weight, err := strconv.Atoi(query.Get("weight"))
+	if err != nil {
+		http.Error(rw, "invalid weight", http.StatusBadRequest)
+		return
+	}
+
+	if err := pool.SetBackendWeight(query.Get("id"), weight); err != nil {
+		switch {
+		case errors.Is(err, registry.ErrBackendNotFound):
+			http.Error(rw, err.Error(), http.StatusNotFound)
+		case errors.Is(err, registry.ErrInvalidWeight):
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
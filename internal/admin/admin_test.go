@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/krapie/l7/internal/backend/register"
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/loadbalancer"
+	"github.com/krapie/l7/internal/stream"
+)
+
+// fakeRegister collects the events sent by the pools.
+type fakeRegister struct {
+	events chan register.BackendEvent
+}
+
+func (r *fakeRegister) SetTargetFilter(string)                      {}
+func (r *fakeRegister) SetRegistry(*registry.BackendRegistry)       {}
+func (r *fakeRegister) GetEventChannel() chan register.BackendEvent { return r.events }
+func (r *fakeRegister) Initialize() error                           { return nil }
+func (r *fakeRegister) Observe()                                    {}
+
+// newTestServer returns an admin server of the pools, each with the backends
+// a and b of weight 1.
+func newTestServer(t *testing.T, names ...string) (*Server, map[string]*fakeRegister) {
+	pools := make(map[string]*loadbalancer.BackendPool)
+	registers := make(map[string]*fakeRegister)
+	for _, name := range names {
+		r := &fakeRegister{events: make(chan register.BackendEvent, 10)}
+		pool := &loadbalancer.BackendPool{
+			Registry: registry.NewRegistry(),
+			Register: r,
+			Streams:  stream.NewRegistry(),
+		}
+		for _, id := range []string{"a", "b"} {
+			if err := pool.Registry.AddBackend(id, "http://127.0.0.1:8080", 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		pools[name] = pool
+		registers[name] = r
+	}
+
+	return NewServer("127.0.0.1:0", pools), registers
+}
+
+func TestHandleBackends(t *testing.T) {
+	s, _ := newTestServer(t, "web", loadbalancer.DefaultPool)
+	b, _ := s.pools["web"].Registry.GetBackendByID("b")
+	b.SetEjected(true)
+
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backends", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var backends []BackendInfo
+	if err := json.NewDecoder(rec.Body).Decode(&backends); err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != 4 {
+		t.Fatalf("%d backends, want 4", len(backends))
+	}
+	// the backends are sorted by pool name
+	if backends[0].Pool != loadbalancer.DefaultPool || backends[3].Pool != "web" {
+		t.Errorf("pools = %s, %s, want sorted", backends[0].Pool, backends[3].Pool)
+	}
+	for _, info := range backends {
+		wantEjected := info.Pool == "web" && info.ID == "b"
+		if info.Ejected != wantEjected || !info.Alive || info.Weight != 1 {
+			t.Errorf("backend %s/%s = %+v", info.Pool, info.ID, info)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/backends", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status of POST = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHandleBackendWeight(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		// wantWeight is the weight of the backend b of the web pool afterwards
+		wantWeight int
+		wantEvent  bool
+	}{
+		{name: "set weight", method: http.MethodPut, target: "/backends/weight?pool=web&id=b&weight=3", wantStatus: http.StatusNoContent, wantWeight: 3, wantEvent: true},
+		{name: "post", method: http.MethodPost, target: "/backends/weight?pool=web&id=b&weight=2", wantStatus: http.StatusNoContent, wantWeight: 2, wantEvent: true},
+		// an unchanged weight doesn't rebuild the load balancers
+		{name: "same weight", method: http.MethodPut, target: "/backends/weight?pool=web&id=b&weight=1", wantStatus: http.StatusNoContent, wantWeight: 1},
+		{name: "get", method: http.MethodGet, target: "/backends/weight?pool=web&id=b&weight=3", wantStatus: http.StatusMethodNotAllowed, wantWeight: 1},
+		{name: "unknown pool", method: http.MethodPut, target: "/backends/weight?pool=api&id=b&weight=3", wantStatus: http.StatusNotFound, wantWeight: 1},
+		{name: "unknown backend", method: http.MethodPut, target: "/backends/weight?pool=web&id=c&weight=3", wantStatus: http.StatusNotFound, wantWeight: 1},
+		{name: "invalid weight", method: http.MethodPut, target: "/backends/weight?pool=web&id=b&weight=heavy", wantStatus: http.StatusBadRequest, wantWeight: 1},
+		{name: "zero weight", method: http.MethodPut, target: "/backends/weight?pool=web&id=b&weight=0", wantStatus: http.StatusBadRequest, wantWeight: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, registers := newTestServer(t, "web")
+
+			rec := httptest.NewRecorder()
+			s.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			b, _ := s.pools["web"].Registry.GetBackendByID("b")
+			if b.GetWeight() != tt.wantWeight {
+				t.Errorf("weight = %d, want %d", b.GetWeight(), tt.wantWeight)
+			}
+
+			select {
+			case event := <-registers["web"].events:
+				if !tt.wantEvent || event.EventType != register.BackendWeightChangedEvent || event.Actor != "b" {
+					t.Errorf("event = %+v, want event %v", event, tt.wantEvent)
+				}
+			default:
+				if tt.wantEvent {
+					t.Error("no weight change event")
+				}
+			}
+		})
+	}
+}
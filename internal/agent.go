@@ -2,61 +2,378 @@ package internal
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"log"
 	"net/http"
+	"reflect"
+	"time"
 
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/krapie/l7/internal/admin"
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/health"
+	"github.com/krapie/l7/internal/backend/outlier"
+	"github.com/krapie/l7/internal/certificate"
 	"github.com/krapie/l7/internal/loadbalancer"
-	"github.com/krapie/l7/internal/loadbalancer/maglev"
+	"github.com/krapie/l7/internal/loadbalancer/hashkey"
+	_ "github.com/krapie/l7/internal/loadbalancer/least_request"
+	_ "github.com/krapie/l7/internal/loadbalancer/maglev"
+	_ "github.com/krapie/l7/internal/loadbalancer/p2c"
+	_ "github.com/krapie/l7/internal/loadbalancer/rendezvous"
+	_ "github.com/krapie/l7/internal/loadbalancer/ring_hash"
+	_ "github.com/krapie/l7/internal/loadbalancer/round_robin"
+	"github.com/krapie/l7/internal/router"
+	"github.com/krapie/l7/internal/stream"
 )
 
 type Config struct {
 	ServiceDiscoveryMode string
 	TargetFilter         string
+	LBAlgorithm          string
 	MaglevHashKey        string
+	HashKeySources       []string
+	HashKeyFallback      string
+	BoundedLoadEpsilon   float64
+	AdminAddr            string
+	BackendProtocol      string
+	StreamPaths          []string
+	StreamMigration      stream.MigrationConfig
+	RetryMaxAttempts     int
+
+	// HealthCheck is read from the config file, and is the health check of the
+	// default pool configured from the flags.
+	HealthCheck health.Config
+	// OutlierDetection is read from the config file, and is the outlier
+	// detection of the default pool configured from the flags.
+	OutlierDetection outlier.Config
+	// CircuitBreaker is read from the config file, and is the circuit breaker
+	// of the default pool configured from the flags.
+	CircuitBreaker backend.CircuitBreakerConfig
+	// Transport is read from the config file, and is the backend transport of
+	// the default pool configured from the flags.
+	Transport backend.TransportConfig
+
+	// Pools, Routes and VirtualHosts are read from the config file. Without
+	// pools, a default pool is configured from the flags. Routes serve the hosts
+	// not matching any virtual host, and without routes and virtual hosts, every
+	// request is sent to the default pool with the load balancing policy of the flags.
+	Pools        []loadbalancer.PoolConfig
+	Routes       []router.RouteConfig
+	VirtualHosts []router.VirtualHostConfig
+	UnknownHost  router.UnknownHostConfig
+
+	// TLS is read from the config file. The HTTPS listener is disabled if its address is empty.
+	TLS certificate.Config
+
+	// HTTPTimeouts are the timeouts of the HTTP listener, and HTTPSTimeouts
+	// the timeouts of the HTTPS listener, whose unset timeouts are the ones of
+	// the HTTP listener.
+	HTTPTimeouts  ListenerTimeouts
+	HTTPSTimeouts ListenerTimeouts
+}
+
+// ListenerTimeouts are the timeouts of the client connections of a listener,
+// unlimited if zero.
+type ListenerTimeouts struct {
+	// ReadTimeout is the maximum duration of reading a request, including its
+	// body, and WriteTimeout of writing its response. They also limit the
+	// duration of streams.
+	ReadTimeout  time.Duration `mapstructure:"read-timeout"`
+	WriteTimeout time.Duration `mapstructure:"write-timeout"`
+	// ReadHeaderTimeout is the maximum duration of reading request headers,
+	// which defeats clients sending them slowly.
+	ReadHeaderTimeout time.Duration `mapstructure:"read-header-timeout"`
+	// IdleTimeout closes keep-alive connections without requests for this long.
+	IdleTimeout time.Duration `mapstructure:"idle-timeout"`
+}
+
+// withDefaults returns the timeouts with their unset timeouts filled from the
+// default timeouts.
+func (t ListenerTimeouts) withDefaults(defaults ListenerTimeouts) ListenerTimeouts {
+	if t.ReadTimeout == 0 {
+		t.ReadTimeout = defaults.ReadTimeout
+	}
+	if t.WriteTimeout == 0 {
+		t.WriteTimeout = defaults.WriteTimeout
+	}
+	if t.ReadHeaderTimeout == 0 {
+		t.ReadHeaderTimeout = defaults.ReadHeaderTimeout
+	}
+	if t.IdleTimeout == 0 {
+		t.IdleTimeout = defaults.IdleTimeout
+	}
+
+	return t
+}
+
+// newServer creates a server of the handler on the address with the timeouts.
+func newServer(addr string, handler http.Handler, timeouts ListenerTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       timeouts.ReadTimeout,
+		WriteTimeout:      timeouts.WriteTimeout,
+		ReadHeaderTimeout: timeouts.ReadHeaderTimeout,
+		IdleTimeout:       timeouts.IdleTimeout,
+	}
 }
 
 type Agent struct {
-	loadBalancer loadbalancer.LoadBalancer
-	httpServer   *http.Server
+	router      *router.HostRouter
+	httpServer  *http.Server
+	httpsServer *http.Server
+	adminServer *admin.Server
+	acmeManager *certificate.ACMEManager
 
 	shutdownCh chan struct{}
 }
 
 func NewAgent(config *Config) (*Agent, error) {
-	// TODO(krapie): we fix LB configuration maglev for now, but we can make it configurable
-	loadBalancer, err := maglev.NewLB(&maglev.Config{
-		ServiceDiscoveryMode: config.ServiceDiscoveryMode,
-		TargetFilter:         config.TargetFilter,
-		MaglevHashKey:        config.MaglevHashKey,
-	})
+	pools, err := newPools(config)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newHostRouter(config, pools)
 	if err != nil {
 		return nil, err
 	}
 
-	http.HandleFunc("/", loadBalancer.ServeProxy)
+	for _, pool := range pools {
+		if err = pool.Start(); err != nil {
+			return nil, err
+		}
+	}
+
+	var handler http.Handler = r
+	var httpsServer *http.Server
+	var acmeManager *certificate.ACMEManager
+	if config.TLS.Addr != "" {
+		var tlsConfig *tls.Config
+		tlsConfig, acmeManager, err = certificate.NewTLSConfig(&config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		if acmeManager != nil {
+			// answer HTTP-01 challenges on the HTTP listener
+			handler = acmeManager.HTTPHandler(r)
+		}
+
+		httpsServer = newServer(config.TLS.Addr, r, config.HTTPSTimeouts.withDefaults(config.HTTPTimeouts))
+		httpsServer.TLSConfig = tlsConfig
+		// negotiate HTTP/2 with ALPN, failing now rather than on start if the
+		// cipher suites don't allow it
+		if err = http2.ConfigureServer(httpsServer, &http2.Server{}); err != nil {
+			return nil, err
+		}
+	}
 
-	// TODO(krapie): temporary specify yorkie related path because http.HandleFunc only support exact match
-	http.HandleFunc("/yorkie.v1.YorkieService/ActivateClient", loadBalancer.ServeProxy)
-	http.HandleFunc("/yorkie.v1.YorkieService/DeactivateClient", loadBalancer.ServeProxy)
-	http.HandleFunc("/yorkie.v1.YorkieService/AttachDocument", loadBalancer.ServeProxy)
-	http.HandleFunc("/yorkie.v1.YorkieService/DetachDocument", loadBalancer.ServeProxy)
-	http.HandleFunc("/yorkie.v1.YorkieService/RemoveDocument", loadBalancer.ServeProxy)
-	http.HandleFunc("/yorkie.v1.YorkieService/PushPullChanges", loadBalancer.ServeProxy)
-	http.HandleFunc("/yorkie.v1.YorkieService/WatchDocument", loadBalancer.ServeProxy)
-	http.HandleFunc("/yorkie.v1.YorkieService/Broadcast", loadBalancer.ServeProxy)
+	// serve cleartext HTTP/2 with prior knowledge or upgrade from HTTP/1.1
+	httpServer := newServer(":80", h2c.NewHandler(handler, &http2.Server{
+		IdleTimeout: config.HTTPTimeouts.IdleTimeout,
+	}), config.HTTPTimeouts)
 
-	httpServer := &http.Server{
-		Addr: ":80",
+	var adminServer *admin.Server
+	if config.AdminAddr != "" {
+		adminServer = admin.NewServer(config.AdminAddr, pools)
 	}
 
 	return &Agent{
-		loadBalancer: loadBalancer,
-		httpServer:   httpServer,
+		router:      r,
+		httpServer:  httpServer,
+		httpsServer: httpsServer,
+		adminServer: adminServer,
+		acmeManager: acmeManager,
 
 		shutdownCh: make(chan struct{}),
 	}, nil
 }
 
+func newPools(config *Config) (map[string]*loadbalancer.BackendPool, error) {
+	poolConfigs := config.Pools
+	if len(poolConfigs) == 0 {
+		poolConfigs = []loadbalancer.PoolConfig{{
+			Name:                 loadbalancer.DefaultPool,
+			ServiceDiscoveryMode: config.ServiceDiscoveryMode,
+			TargetFilter:         config.TargetFilter,
+			BackendProtocol:      config.BackendProtocol,
+			HealthCheck:          config.HealthCheck,
+			OutlierDetection:     config.OutlierDetection,
+			CircuitBreaker:       config.CircuitBreaker,
+			Transport:            config.Transport,
+		}}
+	}
+
+	pools := make(map[string]*loadbalancer.BackendPool)
+	for i := range poolConfigs {
+		poolConfig := poolConfigs[i]
+		if _, ok := pools[poolConfig.Name]; ok {
+			return nil, fmt.Errorf("pool %s configured twice", poolConfig.Name)
+		}
+		if poolConfig.ServiceDiscoveryMode == "" {
+			poolConfig.ServiceDiscoveryMode = config.ServiceDiscoveryMode
+		}
+		if poolConfig.BackendProtocol == "" {
+			poolConfig.BackendProtocol = config.BackendProtocol
+		}
+
+		pool, err := loadbalancer.NewBackendPool(&poolConfig)
+		if err != nil {
+			return nil, err
+		}
+		pools[poolConfig.Name] = pool
+	}
+
+	return pools, nil
+}
+
+func newHostRouter(config *Config, pools map[string]*loadbalancer.BackendPool) (*router.HostRouter, error) {
+	loadBalancers := &loadBalancerCache{}
+
+	var hosts []*router.VirtualHost
+	for _, hostConfig := range config.VirtualHosts {
+		if len(hostConfig.Routes) == 0 {
+			return nil, fmt.Errorf("virtual host %s: no routes configured", hostConfig.Name)
+		}
+
+		r, err := newRouter(config, hostConfig.Routes, pools, loadBalancers)
+		if err != nil {
+			return nil, fmt.Errorf("virtual host %s: %w", hostConfig.Name, err)
+		}
+
+		hosts = append(hosts, &router.VirtualHost{
+			Name:    hostConfig.Name,
+			Domains: hostConfig.Domains,
+			Router:  r,
+		})
+	}
+
+	// routes of unknown hosts, which are every host without virtual hosts
+	defaultRoutes := config.Routes
+	if len(defaultRoutes) == 0 {
+		pool := config.UnknownHost.Pool
+		if len(config.VirtualHosts) == 0 {
+			pool = loadbalancer.DefaultPool
+		}
+
+		if pool != "" {
+			defaultRoutes = []router.RouteConfig{{
+				Name:  pool,
+				Match: router.MatchConfig{Prefix: "/"},
+				Pool:  pool,
+			}}
+		}
+	}
+
+	var defaultRouter *router.Router
+	if len(defaultRoutes) > 0 {
+		var err error
+		defaultRouter, err = newRouter(config, defaultRoutes, pools, loadBalancers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return router.NewHostRouter(hosts, defaultRouter, config.UnknownHost.Status)
+}
+
+func newRouter(config *Config, routeConfigs []router.RouteConfig, pools map[string]*loadbalancer.BackendPool, loadBalancers *loadBalancerCache) (*router.Router, error) {
+	var routes []*router.Route
+	for i := range routeConfigs {
+		routeConfig := routeConfigs[i]
+		if len(routeConfig.StreamPaths) == 0 {
+			routeConfig.StreamPaths = config.StreamPaths
+		}
+
+		pool, ok := pools[routeConfig.Pool]
+		if !ok {
+			return nil, fmt.Errorf("route %s: pool %s not found", routeConfig.Name, routeConfig.Pool)
+		}
+
+		policy := withDefaultPolicy(config, routeConfig.Config)
+		loadBalancer, err := loadBalancers.get(pool, &policy)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", routeConfig.Name, err)
+		}
+
+		route, err := router.NewRoute(&routeConfig, loadBalancer)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	return router.NewRouter(routes), nil
+}
+
+// loadBalancerCache shares one load balancer between the routes with the same
+// pool and policy, so that key-affinity algorithms keep a single lookup table,
+// backend subscription and stream migrator per pool rather than one per route.
+type loadBalancerCache struct {
+	entries []loadBalancerEntry
+}
+
+type loadBalancerEntry struct {
+	pool         *loadbalancer.BackendPool
+	policy       loadbalancer.Config
+	loadBalancer loadbalancer.LoadBalancer
+}
+
+// get returns the load balancer of the pool and policy, created on first use.
+func (c *loadBalancerCache) get(pool *loadbalancer.BackendPool, policy *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+	for _, entry := range c.entries {
+		if entry.pool == pool && reflect.DeepEqual(entry.policy, *policy) {
+			return entry.loadBalancer, nil
+		}
+	}
+
+	loadBalancer, err := loadbalancer.NewLoadBalancer(pool, policy)
+	if err != nil {
+		return nil, err
+	}
+	c.entries = append(c.entries, loadBalancerEntry{pool: pool, policy: *policy, loadBalancer: loadBalancer})
+
+	return loadBalancer, nil
+}
+
+// withDefaultPolicy fills the unset fields of the load balancing policy of a
+// route with the policy of the flags.
+func withDefaultPolicy(config *Config, policy loadbalancer.Config) loadbalancer.Config {
+	if policy.Algorithm == "" {
+		policy.Algorithm = config.LBAlgorithm
+	}
+
+	// use the maglev hash key header, or the document key of Yorkie requests
+	// without the header, unless sources are configured
+	if len(policy.HashKeySources) == 0 {
+		policy.HashKeySources = config.HashKeySources
+	}
+	if len(policy.HashKeySources) == 0 {
+		policy.HashKeySources = []string{hashkey.SourceHeader + ":" + config.MaglevHashKey, hashkey.SourceYorkieBody}
+	}
+
+	if policy.HashKeyFallback == "" {
+		policy.HashKeyFallback = config.HashKeyFallback
+	}
+	if policy.BoundedLoadEpsilon == 0 {
+		policy.BoundedLoadEpsilon = config.BoundedLoadEpsilon
+	}
+	if policy.MigrationConfig.Rate == 0 {
+		policy.MigrationConfig.Rate = config.StreamMigration.Rate
+	}
+	if policy.MigrationConfig.GracePeriod == 0 {
+		policy.MigrationConfig.GracePeriod = config.StreamMigration.GracePeriod
+	}
+	if policy.Retry.MaxAttempts == 0 {
+		policy.Retry.MaxAttempts = config.RetryMaxAttempts
+	}
+
+	return policy
+}
+
 func (s *Agent) Start() error {
 	go func() {
 		log.Printf("[Agent] Starting server on :80")
@@ -68,20 +385,54 @@ func (s *Agent) Start() error {
 		return
 	}()
 
+	if s.httpsServer != nil {
+		go func() {
+			log.Printf("[Agent] Starting TLS server on %s", s.httpsServer.Addr)
+			// certificates are provided by the TLS config of the server
+			if err := s.httpsServer.ListenAndServeTLS("", ""); err != nil {
+				log.Printf("[Agent] TLS server error: %v", err)
+			}
+		}()
+	}
+
+	if s.adminServer != nil {
+		s.adminServer.Start()
+	}
+
+	// obtain the ACME certificates once the listeners can answer challenges
+	if s.acmeManager != nil {
+		s.acmeManager.Monitor()
+	}
+
 	return nil
 }
 
 func (s *Agent) Shutdown(graceful bool) error {
-	if graceful {
-		if err := s.httpServer.Shutdown(context.Background()); err != nil {
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(context.Background()); err != nil {
 			return err
 		}
+	}
+
+	servers := []*http.Server{s.httpServer}
+	if s.httpsServer != nil {
+		servers = append(servers, s.httpsServer)
+	}
+
+	if graceful {
+		for _, server := range servers {
+			if err := server.Shutdown(context.Background()); err != nil {
+				return err
+			}
+		}
 
 		return nil
 	}
 
-	if err := s.httpServer.Close(); err != nil {
-		return err
+	for _, server := range servers {
+		if err := server.Close(); err != nil {
+			return err
+		}
 	}
 
 	close(s.shutdownCh)
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/loadbalancer"
+	"github.com/krapie/l7/internal/loadbalancer/hashkey"
+	"github.com/krapie/l7/internal/loadbalancer/maglev"
+)
+
+func TestLoadBalancerCache(t *testing.T) {
+	pool := &loadbalancer.BackendPool{Registry: registry.NewRegistry()}
+	other := &loadbalancer.BackendPool{Registry: registry.NewRegistry()}
+	policy := loadbalancer.Config{
+		Algorithm:       maglev.Algorithm,
+		HashKeySources:  []string{"header:X-Shard-Key"},
+		HashKeyFallback: hashkey.FallbackRandom,
+	}
+
+	cache := &loadBalancerCache{}
+	get := func(pool *loadbalancer.BackendPool, policy loadbalancer.Config) loadbalancer.LoadBalancer {
+		loadBalancer, err := cache.get(pool, &policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return loadBalancer
+	}
+
+	shared := get(pool, policy)
+	if get(pool, policy) != shared {
+		t.Error("routes with the same pool and policy got different load balancers")
+	}
+	// policies are compared by value, as each route has its own copy
+	same := policy
+	same.HashKeySources = []string{"header:X-Shard-Key"}
+	if get(pool, same) != shared {
+		t.Error("routes with equal policies got different load balancers")
+	}
+	if get(other, policy) == shared {
+		t.Error("routes of different pools share a load balancer")
+	}
+
+	bounded := policy
+	bounded.BoundedLoadEpsilon = 0.25
+	if get(pool, bounded) == shared {
+		t.Error("routes with different policies share a load balancer")
+	}
+}
@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Attempt is the outcome of an attempt to proxy a request to a backend, which
+// retry policies use to decide whether to retry the request on another backend.
+type Attempt struct {
+	// Backend is the backend chosen for the attempt, nil if no backend was available.
+	Backend *Backend
+	// Result is the result of the request, zero if it wasn't sent to the backend.
+	Result Result
+	// Overflow is the circuit breaker limit which rejected the request, if any.
+	Overflow string
+}
+
+// Sent returns whether the request may have reached the backend, which makes
+// retrying a non-idempotent request unsafe.
+func (a *Attempt) Sent() bool {
+	if a.Backend == nil || a.Overflow != "" {
+		return false
+	}
+
+	return a.Result.Err == nil || !IsConnectFailure(a.Result.Err)
+}
+
+// IsConnectFailure returns whether the error is a failure to connect to the
+// backend, before the request was sent.
+func IsConnectFailure(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// WithAttempt returns a context recording the attempt of its request.
+func WithAttempt(ctx context.Context, attempt *Attempt) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+func attemptOf(ctx context.Context) *Attempt {
+	attempt, _ := ctx.Value(attemptKey{}).(*Attempt)
+	return attempt
+}
+
+type attemptKey struct{}
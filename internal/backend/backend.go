@@ -2,60 +2,195 @@ package backend
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/krapie/l7/internal/grpc"
 )
 
 const (
 	ALIVE_UP   = true
 	ALIVE_DOWN = false
+
+	DefaultWeight = 1
 )
 
 type Backend struct {
-	ID    string
-	Addr  *url.URL
-	Alive bool
+	ID     string
+	Addr   *url.URL
+	Alive  bool
+	Weight int
 
 	mutex sync.RWMutex
 	proxy *httputil.ReverseProxy
+	// upgradeTransport sends the upgrade requests of the backend over HTTP/1.1.
+	upgradeTransport *http.Transport
+
+	// activeRequests is the number of requests currently being served by the backend.
+	activeRequests int64
+	// latency is the time taken by the backend to respond with headers.
+	latency *PeakEWMA
+
+	// ejected is true while the backend is ejected by outlier detection. It is
+	// kept apart from Alive, which is the result of active health checks.
+	ejected bool
+	// observer is notified of the result of every proxied request, if not nil.
+	observer ResultObserver
+	// breaker limits the requests and retries of the backend.
+	breaker *circuitBreaker
 }
 
+// Result is the outcome of a request proxied to a backend.
+type Result struct {
+	// Status is the status of the response, zero if the backend didn't respond.
+	Status int
+	// Err is the error of a request the backend didn't respond to, such as a
+	// connection failure.
+	Err error
+}
+
+// ResultObserver is notified of the results of the requests of a backend.
+type ResultObserver func(b *Backend, result Result)
+
 func NewDefaultBackend(ID, addr string) (*Backend, error) {
+	// the default transport of HTTP/1.1 can't fail to be created
+	transport, _ := NewTransport(ProtocolHTTP1, nil)
+	return NewBackend(ID, addr, ProtocolHTTP1, transport)
+}
+
+// NewBackend creates a backend speaking the protocol, which sends requests
+// with the given transport.
+func NewBackend(ID, addr, protocol string, transport *Transport) (*Backend, error) {
 	parsedAddr, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
 	}
+	parsedAddr.Scheme = schemeOf(protocol)
 
 	proxy := httputil.NewSingleHostReverseProxy(parsedAddr)
+	proxy.Transport = transport.RoundTripper
+	proxy.BufferPool = sharedBufferPool
+	b := &Backend{
+		ID:     ID,
+		Addr:   parsedAddr,
+		Alive:  true,
+		Weight: DefaultWeight,
+
+		mutex:            sync.RWMutex{},
+		proxy:            proxy,
+		upgradeTransport: transport.Upgrade,
+		latency:          NewPeakEWMA(DefaultLatencyDecay),
+		breaker:          &circuitBreaker{},
+	}
+
 	proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-		retries := getRetryFromContext(req)
-		if retries < 3 {
-			select {
-			case <-time.After(100 * time.Millisecond):
-				req = setRetryToContext(req, retries+1)
-				proxy.ServeHTTP(rw, req)
-				return
+		cause := context.Cause(req.Context())
+
+		// the backend didn't respond in time
+		if errors.Is(cause, ErrResponseHeaderTimeout) {
+			b.latency.Observe(errorLatencyPenalty)
+			b.observe(req, Result{Err: cause})
+			grpc.Error(rw, req, cause.Error(), http.StatusGatewayTimeout)
+			return
+		}
+
+		// the request was canceled by l7 with a cause for the client, such as a
+		// timeout of its route or a stream moving to another backend
+		if cause != nil && !errors.Is(cause, context.Canceled) && !errors.Is(cause, context.DeadlineExceeded) {
+			status := http.StatusServiceUnavailable
+			if IsTimeout(cause) {
+				status = http.StatusGatewayTimeout
 			}
+			grpc.Error(rw, req, cause.Error(), status)
+			return
+		}
+
+		// a failing backend often fails fast, so penalize it rather than
+		// letting latency-aware load balancers see it as the fastest one,
+		// unless the request ended on its own, at the deadline of its client or
+		// by its client going away
+		if req.Context().Err() == nil {
+			b.latency.Observe(errorLatencyPenalty)
+			b.observe(req, Result{Err: err})
 		}
 
-		http.Error(rw, "Error occurred while processing request", http.StatusBadGateway)
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		grpc.Error(rw, req, "Error occurred while processing request", status)
 	}
 
-	return &Backend{
-		ID:    ID,
-		Addr:  parsedAddr,
-		Alive: true,
+	// record the latency until response headers rather than until the end of the
+	// body, so that long-lived streams don't count as slow responses
+	proxy.ModifyResponse = func(res *http.Response) error {
+		stopResponseHeaderTimer(res.Request.Context())
+		if start, ok := res.Request.Context().Value(serveStartKey{}).(time.Time); ok {
+			b.latency.Observe(time.Since(start))
+		}
+		b.observe(res.Request, Result{Status: res.StatusCode})
+
+		if grpc.IsGRPC(res.Request) && !grpc.IsGRPCResponse(res) {
+			translateToGRPC(res)
+		}
+		return nil
+	}
 
-		mutex: sync.RWMutex{},
-		proxy: proxy,
-	}, nil
+	return b, nil
 }
 
+// Serve proxies the request to the backend. The request is counted as active
+// until the response, including streamed responses, is fully written, and is
+// rejected with a 503 if the backend is at the limits of its circuit breaker.
+// Upgrade requests, such as WebSocket, are proxied by ServeUpgrade.
 func (b *Backend) Serve(rw http.ResponseWriter, req *http.Request) {
-	b.proxy.ServeHTTP(rw, req)
+	if IsUpgrade(req) {
+		b.ServeUpgrade(rw, req, IdleTimeout(req.Context()), nil)
+		return
+	}
+
+	if attempt := attemptOf(req.Context()); attempt != nil {
+		attempt.Backend = b
+	}
+	gotConn, done, ok := b.admit(rw, req)
+	if !ok {
+		return
+	}
+	defer done()
+
+	ctx := context.WithValue(req.Context(), serveStartKey{}, time.Now())
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			gotConn()
+		},
+	})
+
+	// give up on gRPC requests at their deadline
+	if value := req.Header.Get(grpc.TimeoutHeader); value != "" && grpc.IsGRPC(req) {
+		if timeout, err := grpc.ParseTimeout(value); err == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+
+	ctx, stop := startResponseHeaderTimer(ctx)
+	defer stop()
+
+	b.proxy.ServeHTTP(rw, req.WithContext(ctx))
+}
+
+// ActiveRequests returns the number of requests currently being served by the backend.
+func (b *Backend) ActiveRequests() int64 {
+	return atomic.LoadInt64(&b.activeRequests)
 }
 
 func (b *Backend) SetAlive(alive bool) {
@@ -72,16 +207,85 @@ func (b *Backend) IsAlive() bool {
 	return alive
 }
 
-func getRetryFromContext(req *http.Request) int {
-	retries := req.Context().Value("retries")
-	if retries == nil {
-		return 0
+// SetEjected ejects the backend from load balancing, or returns it.
+func (b *Backend) SetEjected(ejected bool) {
+	b.mutex.Lock()
+	b.ejected = ejected
+	b.mutex.Unlock()
+}
+
+func (b *Backend) IsEjected() bool {
+	b.mutex.RLock()
+	ejected := b.ejected
+	b.mutex.RUnlock()
+
+	return ejected
+}
+
+// IsAvailable returns whether load balancers may send requests to the backend,
+// which is alive and not ejected.
+func (b *Backend) IsAvailable() bool {
+	b.mutex.RLock()
+	available := b.Alive && !b.ejected
+	b.mutex.RUnlock()
+
+	return available
+}
+
+// SetResultObserver sets the observer of the results of the requests of the
+// backend. It must be set before the backend serves requests.
+func (b *Backend) SetResultObserver(observer ResultObserver) {
+	b.observer = observer
+}
+
+// observe notifies the observer and the attempt of the request of the result.
+func (b *Backend) observe(req *http.Request, result Result) {
+	if attempt := attemptOf(req.Context()); attempt != nil {
+		attempt.Result = result
 	}
+	if b.observer != nil {
+		b.observer(b, result)
+	}
+}
+
+// Latency returns the peak EWMA of the time taken by the backend to respond with headers.
+func (b *Backend) Latency() time.Duration {
+	return b.latency.Value()
+}
+
+func (b *Backend) SetWeight(weight int) {
+	b.mutex.Lock()
+	b.Weight = weight
+	b.mutex.Unlock()
+}
+
+func (b *Backend) GetWeight() int {
+	b.mutex.RLock()
+	weight := b.Weight
+	b.mutex.RUnlock()
 
-	return retries.(int)
+	return weight
 }
 
-func setRetryToContext(req *http.Request, retries int) *http.Request {
-	ctx := context.WithValue(req.Context(), "retries", retries)
-	return req.WithContext(ctx)
+// translateToGRPC replaces a non-gRPC response to a gRPC request, such as an
+// error page of the backend, with a trailers-only response of the matching
+// gRPC status, so that gRPC clients see a status they can act on.
+func translateToGRPC(res *http.Response) {
+	code := grpc.CodeFromHTTP(res.StatusCode)
+	message := fmt.Sprintf("backend responded with HTTP status %d", res.StatusCode)
+
+	_ = res.Body.Close()
+	res.Body = http.NoBody
+	res.ContentLength = 0
+	res.StatusCode = http.StatusOK
+	res.Status = "200 OK"
+
+	res.Header = http.Header{}
+	res.Header.Set("Content-Type", res.Request.Header.Get("Content-Type"))
+	res.Header.Set(grpc.StatusHeader, strconv.Itoa(int(code)))
+	res.Header.Set(grpc.MessageHeader, grpc.EncodeMessage(message))
+	res.Trailer = nil
 }
+
+// serveStartKey is the context key of the time when the backend started serving the request.
+type serveStartKey struct{}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krapie/l7/internal/grpc"
+)
+
+func TestErrorHandlerObserve(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	// a listener which is closed refuses connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + listener.Addr().String()
+	_ = listener.Close()
+
+	tests := []struct {
+		name        string
+		addr        string
+		header      http.Header
+		cancel      time.Duration
+		wantObserve bool
+	}{
+		{name: "connection refused", addr: refused, wantObserve: true},
+		{
+			name:   "grpc-timeout of the client",
+			addr:   slow.URL,
+			header: http.Header{"Content-Type": {"application/grpc"}, grpc.TimeoutHeader: {"50m"}},
+		},
+		{name: "client gone", addr: slow.URL, cancel: 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewDefaultBackend("backend", tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var observed bool
+			b.SetResultObserver(func(*Backend, Result) {
+				observed = true
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			if tt.cancel > 0 {
+				ctx, cancel := context.WithTimeout(req.Context(), tt.cancel)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+
+			b.Serve(httptest.NewRecorder(), req)
+			if observed != tt.wantObserve {
+				t.Errorf("observed = %v, want %v", observed, tt.wantObserve)
+			}
+			// the penalty decays from the moment it is observed
+			if penalized := b.Latency() > errorLatencyPenalty/2; penalized != tt.wantObserve {
+				t.Errorf("latency = %s, want penalized %v", b.Latency(), tt.wantObserve)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package backend
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/krapie/l7/internal/grpc"
+)
+
+const (
+	// OverflowHeader is the header of the responses rejected by a circuit
+	// breaker, holding the name of the exceeded limit.
+	OverflowHeader = "X-L7-Overflow"
+
+	OverflowMaxPendingRequests = "max-pending-requests"
+	OverflowMaxRequests        = "max-requests"
+)
+
+var (
+	// ErrMaxConnectionsNotSupported is returned for a connection limit on a
+	// protocol multiplexing requests on a single connection, such as HTTP/2.
+	ErrMaxConnectionsNotSupported = errors.New("max-connections is only supported by HTTP/1.1 backends, use max-requests")
+
+	overflows = expvar.NewMap("l7_circuit_breaker_overflows")
+)
+
+// CircuitBreakerConfig is the limits of the resources used by each backend of
+// a pool, so that a stalled backend doesn't pile up requests. Zero limits are
+// unlimited.
+type CircuitBreakerConfig struct {
+	// MaxConnections is the maximum number of connections to a backend over
+	// HTTP/1.1. Requests wait for a connection once it is reached. It is an
+	// error for HTTP/2 backends.
+	MaxConnections int `mapstructure:"max-connections"`
+	// MaxPendingRequests is the maximum number of requests waiting for a
+	// connection to a backend.
+	MaxPendingRequests int `mapstructure:"max-pending-requests"`
+	// MaxRequests is the maximum number of concurrent requests to a backend,
+	// including streams.
+	MaxRequests int `mapstructure:"max-requests"`
+	// MaxRetries is the maximum number of concurrent retries to a backend.
+	MaxRetries int `mapstructure:"max-retries"`
+}
+
+// circuitBreaker tracks the resources used by a backend against the limits of
+// its pool.
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+
+	pendingRequests int64
+	retries         int64
+}
+
+// SetCircuitBreaker sets the limits of the backend. It must be set before the
+// backend serves requests.
+func (b *Backend) SetCircuitBreaker(config CircuitBreakerConfig) {
+	b.breaker = &circuitBreaker{config: config}
+}
+
+// PendingRequests returns the number of requests waiting for a connection to the backend.
+func (b *Backend) PendingRequests() int64 {
+	return atomic.LoadInt64(&b.breaker.pendingRequests)
+}
+
+// admit counts the request as active, unless the backend is at its limit of
+// concurrent or pending requests, in which case it rejects the request with a
+// 503 and returns false. The request is pending until the returned gotConn is
+// called, and is active until the returned done is called.
+func (b *Backend) admit(rw http.ResponseWriter, req *http.Request) (gotConn func(), done func(), ok bool) {
+	config := b.breaker.config
+
+	if active := atomic.AddInt64(&b.activeRequests, 1); config.MaxRequests > 0 && active > int64(config.MaxRequests) {
+		atomic.AddInt64(&b.activeRequests, -1)
+		b.overflow(rw, req, OverflowMaxRequests)
+		return nil, nil, false
+	}
+
+	if pending := atomic.AddInt64(&b.breaker.pendingRequests, 1); config.MaxPendingRequests > 0 && pending > int64(config.MaxPendingRequests) {
+		atomic.AddInt64(&b.breaker.pendingRequests, -1)
+		atomic.AddInt64(&b.activeRequests, -1)
+		b.overflow(rw, req, OverflowMaxPendingRequests)
+		return nil, nil, false
+	}
+
+	var once sync.Once
+	gotConn = func() {
+		once.Do(func() {
+			atomic.AddInt64(&b.breaker.pendingRequests, -1)
+		})
+	}
+	done = func() {
+		gotConn()
+		atomic.AddInt64(&b.activeRequests, -1)
+	}
+
+	return gotConn, done, true
+}
+
+// AcquireRetry returns whether a request failed by the backend may be retried
+// within its limit of concurrent retries, and if so, the release function of
+// the retry.
+func (b *Backend) AcquireRetry() (release func(), ok bool) {
+	maxRetries := b.breaker.config.MaxRetries
+	if retries := atomic.AddInt64(&b.breaker.retries, 1); maxRetries > 0 && retries > int64(maxRetries) {
+		atomic.AddInt64(&b.breaker.retries, -1)
+		overflows.Add(b.ID, 1)
+		return nil, false
+	}
+
+	return func() {
+		atomic.AddInt64(&b.breaker.retries, -1)
+	}, true
+}
+
+// overflow rejects the request without sending it to the backend, so that the
+// client can retry elsewhere right away.
+func (b *Backend) overflow(rw http.ResponseWriter, req *http.Request, limit string) {
+	overflows.Add(b.ID, 1)
+	if attempt := attemptOf(req.Context()); attempt != nil {
+		attempt.Overflow = limit
+	}
+	rw.Header().Set(OverflowHeader, limit)
+	grpc.Error(rw, req, "Backend "+b.ID+" is overloaded", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdmit(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       CircuitBreakerConfig
+		wantOverflow string
+	}{
+		{name: "max requests", config: CircuitBreakerConfig{MaxRequests: 1}, wantOverflow: OverflowMaxRequests},
+		{name: "max pending requests", config: CircuitBreakerConfig{MaxPendingRequests: 1}, wantOverflow: OverflowMaxPendingRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := NewDefaultBackend("backend", "http://127.0.0.1:8080")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.SetCircuitBreaker(tt.config)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			_, done, ok := b.admit(httptest.NewRecorder(), req)
+			if !ok {
+				t.Fatal("first request rejected")
+			}
+
+			attempt := &Attempt{}
+			rec := httptest.NewRecorder()
+			if _, _, ok = b.admit(rec, req.WithContext(WithAttempt(req.Context(), attempt))); ok {
+				t.Fatal("request over the limit admitted")
+			}
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+			}
+			if got := rec.Header().Get(OverflowHeader); got != tt.wantOverflow {
+				t.Errorf("%s = %q, want %q", OverflowHeader, got, tt.wantOverflow)
+			}
+			if attempt.Overflow != tt.wantOverflow {
+				t.Errorf("attempt overflow = %q, want %q", attempt.Overflow, tt.wantOverflow)
+			}
+
+			done()
+			if b.ActiveRequests() != 0 || b.PendingRequests() != 0 {
+				t.Errorf("active = %d, pending = %d after done, want 0", b.ActiveRequests(), b.PendingRequests())
+			}
+			if _, done, ok = b.admit(httptest.NewRecorder(), req); !ok {
+				t.Fatal("request rejected after a release")
+			}
+			done()
+		})
+	}
+}
+
+func TestAdmitGotConn(t *testing.T) {
+	b, err := NewDefaultBackend("backend", "http://127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetCircuitBreaker(CircuitBreakerConfig{MaxPendingRequests: 1})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	gotConn, done, ok := b.admit(httptest.NewRecorder(), req)
+	if !ok {
+		t.Fatal("first request rejected")
+	}
+
+	// a request which got its connection is no longer pending
+	gotConn()
+	gotConn()
+	if b.PendingRequests() != 0 {
+		t.Errorf("pending = %d after gotConn, want 0", b.PendingRequests())
+	}
+	_, done2, ok := b.admit(httptest.NewRecorder(), req)
+	if !ok {
+		t.Fatal("request rejected while the other one is connected")
+	}
+
+	done()
+	done2()
+	if b.ActiveRequests() != 0 || b.PendingRequests() != 0 {
+		t.Errorf("active = %d, pending = %d after done, want 0", b.ActiveRequests(), b.PendingRequests())
+	}
+}
+
+func TestAcquireRetry(t *testing.T) {
+	b, err := NewDefaultBackend("backend", "http://127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetCircuitBreaker(CircuitBreakerConfig{MaxRetries: 1})
+
+	release, ok := b.AcquireRetry()
+	if !ok {
+		t.Fatal("first retry rejected")
+	}
+	if _, ok = b.AcquireRetry(); ok {
+		t.Fatal("retry over the limit allowed")
+	}
+
+	release()
+	if release, ok = b.AcquireRetry(); !ok {
+		t.Fatal("retry rejected after a release")
+	}
+	release()
+}
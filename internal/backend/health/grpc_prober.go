@@ -0,0 +1,154 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/grpc"
+)
+
+const (
+	TypeGRPC  = "grpc"
+	TypeGRPCS = "grpcs"
+
+	healthCheckPath = "/grpc.health.v1.Health/Check"
+
+	// field numbers of HealthCheckRequest.service and HealthCheckResponse.status
+	healthCheckServiceField protowire.Number = 1
+	healthCheckStatusField  protowire.Number = 1
+
+	// servingStatus is the SERVING value of HealthCheckResponse.ServingStatus.
+	servingStatus = 1
+)
+
+var (
+	ErrNotServing = errors.New("gRPC health check status is not SERVING")
+)
+
+// GRPCProber considers a backend healthy if it reports SERVING to the gRPC
+// Health Checking Protocol, over cleartext HTTP/2 or TLS.
+type GRPCProber struct {
+	scheme  string
+	host    string
+	service string
+
+	client *http.Client
+}
+
+func NewGRPCProber(config *Config, transport *backend.Transport) *GRPCProber {
+	probeTransport := &http2.Transport{
+		TLSClientConfig: newProbeTLSConfig(config, transport),
+	}
+	scheme := "https"
+	if config.Type == TypeGRPC {
+		scheme = "http"
+		probeTransport.AllowHTTP = true
+		probeTransport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return &GRPCProber{
+		scheme:  scheme,
+		host:    config.Host,
+		service: config.Service,
+
+		client: &http.Client{Transport: probeTransport},
+	}
+}
+
+func (p *GRPCProber) Probe(ctx context.Context, b *backend.Backend) error {
+	var message []byte
+	if p.service != "" {
+		message = protowire.AppendTag(message, healthCheckServiceField, protowire.BytesType)
+		message = protowire.AppendString(message, p.service)
+	}
+	body := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	body = append(body, message...)
+
+	url := fmt.Sprintf("%s://%s%s", p.scheme, b.Addr.Host, healthCheckPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if p.host != "" {
+		req.Host = p.host
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected health check status: %d", res.StatusCode)
+	}
+
+	response, err := io.ReadAll(io.LimitReader(res.Body, maxBodyBytes))
+	if err != nil {
+		return err
+	}
+
+	// the status is in the headers of trailers-only responses
+	status := res.Trailer.Get(grpc.StatusHeader)
+	if status == "" {
+		status = res.Header.Get(grpc.StatusHeader)
+	}
+	if status != "0" {
+		return fmt.Errorf("gRPC health check failed with status %s: %s", status, res.Trailer.Get(grpc.MessageHeader))
+	}
+
+	if len(response) < 5 || response[0] != 0 {
+		return fmt.Errorf("invalid gRPC health check response")
+	}
+	if decodeServingStatus(response[5:]) != servingStatus {
+		return ErrNotServing
+	}
+
+	return nil
+}
+
+// decodeServingStatus returns the status of a HealthCheckResponse, zero (UNKNOWN) if absent.
+func decodeServingStatus(message []byte) uint64 {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return 0
+		}
+		message = message[n:]
+
+		if num == healthCheckStatusField && typ == protowire.VarintType {
+			status, n := protowire.ConsumeVarint(message)
+			if n < 0 {
+				return 0
+			}
+			return status
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, message)
+		if n < 0 {
+			return 0
+		}
+		message = message[n:]
+	}
+
+	return 0
+}
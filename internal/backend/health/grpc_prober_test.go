@@ -0,0 +1,163 @@
+package health
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/grpc"
+)
+
+// healthServer answers the gRPC health checks of the services with their
+// serving status, and NOT_FOUND for the other services.
+func healthServer(statuses map[string]uint64) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil || req.URL.Path != healthCheckPath || len(body) < 5 {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var service string
+		message := body[5:]
+		if num, typ, n := protowire.ConsumeTag(message); n > 0 && num == healthCheckServiceField && typ == protowire.BytesType {
+			service, _ = protowire.ConsumeString(message[n:])
+		}
+
+		rw.Header().Set("Content-Type", "application/grpc")
+		status, ok := statuses[service]
+		if !ok {
+			// trailers-only response
+			rw.Header().Set(grpc.StatusHeader, "5")
+			rw.Header().Set(grpc.MessageHeader, "unknown service")
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+
+		rw.Header().Set("Trailer", grpc.StatusHeader)
+		response := protowire.AppendTag(nil, healthCheckStatusField, protowire.VarintType)
+		response = protowire.AppendVarint(response, status)
+		frame := make([]byte, 5, 5+len(response))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(response)))
+		_, _ = rw.Write(append(frame, response...))
+		rw.Header().Set(grpc.StatusHeader, "0")
+	})
+}
+
+func TestGRPCProber(t *testing.T) {
+	statuses := map[string]uint64{
+		"":                         servingStatus,
+		"yorkie.v1.YorkieService":  servingStatus,
+		"yorkie.v1.AdminService":   2, // NOT_SERVING
+		"yorkie.v1.ClusterService": 0, // UNKNOWN
+	}
+	server := httptest.NewServer(h2c.NewHandler(healthServer(statuses), &http2.Server{}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		service string
+		wantErr bool
+	}{
+		{name: "whole server", service: ""},
+		{name: "serving service", service: "yorkie.v1.YorkieService"},
+		{name: "not serving service", service: "yorkie.v1.AdminService", wantErr: true},
+		{name: "unknown status", service: "yorkie.v1.ClusterService", wantErr: true},
+		{name: "unknown service", service: "yorkie.v1.UnknownService", wantErr: true},
+	}
+
+	b, err := backend.NewDefaultBackend("backend", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prober := NewGRPCProber(&Config{Type: TypeGRPC, Service: tt.service}, nil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := prober.Probe(ctx, b); (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGRPCProberTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(healthServer(map[string]uint64{"": servingStatus}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	caFile := writeCertificate(t, server.Certificate())
+
+	tests := []struct {
+		name     string
+		protocol string
+		config   *backend.TransportConfig
+		wantErr  bool
+	}{
+		{name: "CA of the pool", protocol: backend.ProtocolH2, config: transportConfig(caFile, "", "")},
+		{name: "no TLS configuration", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := backend.NewTransport(tt.protocol, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := backend.NewBackend("backend", server.URL, tt.protocol, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prober, err := NewProber(&Config{Type: TypeGRPCS}, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := prober.Probe(ctx, b); (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeServingStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+		want    uint64
+	}{
+		{name: "empty", message: nil, want: 0},
+		{name: "serving", message: protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1), want: 1},
+		{
+			name: "unknown field first",
+			message: protowire.AppendVarint(protowire.AppendTag(
+				protowire.AppendString(protowire.AppendTag(nil, 2, protowire.BytesType), "extra"),
+				1, protowire.VarintType), 2),
+			want: 2,
+		},
+		{name: "truncated", message: protowire.AppendTag(nil, 1, protowire.VarintType), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeServingStatus(tt.message); got != tt.want {
+				t.Errorf("decodeServingStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package health
 
 import (
-	"net"
-	"net/url"
+	"context"
+	"log"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/krapie/l7/internal/backend"
@@ -12,20 +14,79 @@ import (
 
 const TCP = "tcp"
 
+const (
+	DefaultInterval           = 2 * time.Second
+	DefaultTimeout            = time.Second
+	DefaultHealthyThreshold   = 2
+	DefaultUnhealthyThreshold = 3
+	DefaultConcurrency        = 8
+)
+
 type Checker struct {
 	backendRegistry *registry.BackendRegistry
 	backendRegister register.Register
+	prober          Prober
+
+	interval           time.Duration
+	timeout            time.Duration
+	healthyThreshold   int
+	unhealthyThreshold int
+	initialDelay       time.Duration
+	jitter             time.Duration
+	concurrency        int
 
-	interval int
+	mutex  sync.Mutex
+	states map[string]*probeState
 }
 
-func NewHealthChecker(registry *registry.BackendRegistry, register register.Register, interval int) *Checker {
-	return &Checker{
+// probeState is the consecutive results of the probes of a backend.
+type probeState struct {
+	inFlight  bool
+	successes int
+	failures  int
+}
+
+// NewHealthChecker creates a health checker of the backends of the registry
+// with the prober of the configured type, connecting with the TLS
+// configuration of the transport of the registry.
+func NewHealthChecker(registry *registry.BackendRegistry, register register.Register, config *Config) (*Checker, error) {
+	prober, err := NewProber(config, registry.Transport())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checker{
 		backendRegistry: registry,
 		backendRegister: register,
+		prober:          prober,
+
+		interval:           config.Interval,
+		timeout:            config.Timeout,
+		healthyThreshold:   config.HealthyThreshold,
+		unhealthyThreshold: config.UnhealthyThreshold,
+		initialDelay:       config.InitialDelay,
+		jitter:             config.Jitter,
+		concurrency:        config.Concurrency,
 
-		interval: interval,
+		states: make(map[string]*probeState),
+	}
+	if c.interval <= 0 {
+		c.interval = DefaultInterval
+	}
+	if c.timeout <= 0 {
+		c.timeout = DefaultTimeout
 	}
+	if c.healthyThreshold <= 0 {
+		c.healthyThreshold = DefaultHealthyThreshold
+	}
+	if c.unhealthyThreshold <= 0 {
+		c.unhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	if c.concurrency <= 0 {
+		c.concurrency = DefaultConcurrency
+	}
+
+	return c, nil
 }
 
 func (c *Checker) Run() {
@@ -33,44 +94,113 @@ func (c *Checker) Run() {
 }
 
 func (c *Checker) healthCheck() {
-	t := time.NewTicker(time.Duration(c.interval) * time.Second)
+	jobs := make(chan *backend.Backend)
+	for i := 0; i < c.concurrency; i++ {
+		go c.work(jobs)
+	}
+
+	time.Sleep(c.initialDelay)
 	for {
-		select {
-		case <-t.C:
-			// log.Printf("[Health] Running health check")
-			c.checkBackendLiveness()
+		c.checkBackendLiveness(jobs)
+		time.Sleep(c.interval)
+	}
+}
+
+// checkBackendLiveness sends the backends to the workers, skipping the
+// backends whose previous probe is still running. Each backend is sent after
+// its own random offset within the jitter, which spreads the probes of the
+// backends, and of replicas and pools started at the same time.
+func (c *Checker) checkBackendLiveness(jobs chan<- *backend.Backend) {
+	backends := c.backendRegistry.GetBackends()
+
+	c.mutex.Lock()
+	var pending []*backend.Backend
+	current := make(map[string]*probeState, len(backends))
+	for _, b := range backends {
+		state, ok := c.states[b.ID]
+		if !ok {
+			state = &probeState{}
 		}
+		current[b.ID] = state
+
+		if !state.inFlight {
+			state.inFlight = true
+			pending = append(pending, b)
+		}
+	}
+	// forget the removed backends
+	c.states = current
+	c.mutex.Unlock()
+
+	for _, b := range pending {
+		if c.jitter <= 0 {
+			jobs <- b
+			continue
+		}
+
+		b := b
+		time.AfterFunc(time.Duration(rand.Int63n(int64(c.jitter))), func() {
+			jobs <- b
+		})
 	}
 }
 
-func (c *Checker) checkBackendLiveness() {
-	for _, b := range c.backendRegistry.GetBackends() {
-		isAlive := c.checkTCPConnection(b.Addr, c.interval)
-		if isAlive && !b.IsAlive() {
-			b.SetAlive(backend.ALIVE_UP)
+func (c *Checker) work(jobs <-chan *backend.Backend) {
+	for b := range jobs {
+		c.record(b, c.probe(b))
+	}
+}
+
+// record updates the consecutive results of the backend, and changes its
+// state once the results reach the threshold of the other state.
+func (c *Checker) record(b *backend.Backend, healthy bool) {
+	c.mutex.Lock()
+	state, ok := c.states[b.ID]
+	if !ok {
+		c.mutex.Unlock()
+		return
+	}
+	state.inFlight = false
+	if healthy {
+		state.successes++
+		state.failures = 0
+	} else {
+		state.failures++
+		state.successes = 0
+	}
+	rise := healthy && state.successes >= c.healthyThreshold
+	fall := !healthy && state.failures >= c.unhealthyThreshold
+	c.mutex.Unlock()
+
+	if rise && !b.IsAlive() {
+		log.Printf("[Health] Backend %s is healthy", b.ID)
+		b.SetAlive(backend.ALIVE_UP)
+		// ejected backends are added back by the outlier detection
+		if !b.IsEjected() {
 			c.backendRegister.GetEventChannel() <- register.BackendEvent{
 				EventType: register.BackendAddedEvent,
 				Actor:     b.ID,
 			}
-		} else if !isAlive {
-			b.SetAlive(backend.ALIVE_DOWN)
-			c.backendRegister.GetEventChannel() <- register.BackendEvent{
-				EventType: register.BackendRemovedEvent,
-				Actor:     b.ID,
-			}
 		}
-		// log.Printf("[Health] Backend %s is %v", b.Addr, b.IsAlive())
+	} else if fall && b.IsAlive() {
+		log.Printf("[Health] Backend %s is unhealthy after %d failed checks", b.ID, c.unhealthyThreshold)
+		b.SetAlive(backend.ALIVE_DOWN)
+		c.backendRegister.GetEventChannel() <- register.BackendEvent{
+			EventType: register.BackendRemovedEvent,
+			Actor:     b.ID,
+		}
 	}
 }
 
-func (c *Checker) checkTCPConnection(addr *url.URL, interval int) bool {
-	conn, err := net.DialTimeout(TCP, addr.Host, time.Duration(interval)*time.Second)
-	if err != nil {
-		return false
-	}
+// probe returns whether the backend is healthy, giving up after the timeout.
+func (c *Checker) probe(b *backend.Backend) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
 
-	err = conn.Close()
-	if err != nil {
+	if err := c.prober.Probe(ctx, b); err != nil {
+		if b.IsAlive() {
+			log.Printf("[Health] Backend %s failed health check: %s", b.ID, err)
+		}
 		return false
 	}
 
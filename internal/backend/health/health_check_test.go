@@ -0,0 +1,200 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/register"
+	"github.com/krapie/l7/internal/backend/registry"
+)
+
+// fakeRegister collects the events sent by the health checker.
+type fakeRegister struct {
+	events chan register.BackendEvent
+}
+
+func newFakeRegister() *fakeRegister {
+	return &fakeRegister{events: make(chan register.BackendEvent, 100)}
+}
+
+func (r *fakeRegister) SetTargetFilter(string)                      {}
+func (r *fakeRegister) SetRegistry(*registry.BackendRegistry)       {}
+func (r *fakeRegister) GetEventChannel() chan register.BackendEvent { return r.events }
+func (r *fakeRegister) Initialize() error                           { return nil }
+func (r *fakeRegister) Observe()                                    {}
+
+// drain returns the types of the events sent so far.
+func (r *fakeRegister) drain() []string {
+	var events []string
+	for {
+		select {
+		case event := <-r.events:
+			events = append(events, event.EventType)
+		default:
+			return events
+		}
+	}
+}
+
+func TestCheckerRecord(t *testing.T) {
+	tests := []struct {
+		name       string
+		healthy    int
+		unhealthy  int
+		alive      bool
+		ejected    bool
+		results    []bool
+		wantAlive  bool
+		wantEvents []string
+	}{
+		{
+			name:       "fall at the default threshold",
+			alive:      true,
+			results:    []bool{false, false, false},
+			wantAlive:  false,
+			wantEvents: []string{register.BackendRemovedEvent},
+		},
+		{
+			name:      "below the default threshold",
+			alive:     true,
+			results:   []bool{false, false},
+			wantAlive: true,
+		},
+		{
+			name:      "success resets failures",
+			alive:     true,
+			results:   []bool{false, false, true, false, false},
+			wantAlive: true,
+		},
+		{
+			name:       "fall after one failure",
+			unhealthy:  1,
+			alive:      true,
+			results:    []bool{false, false},
+			wantAlive:  false,
+			wantEvents: []string{register.BackendRemovedEvent},
+		},
+		{
+			name:       "rise at the default threshold",
+			results:    []bool{true, true},
+			wantAlive:  true,
+			wantEvents: []string{register.BackendAddedEvent},
+		},
+		{
+			name:      "below the rise threshold",
+			healthy:   3,
+			results:   []bool{true, true, false, true, true},
+			wantAlive: false,
+		},
+		{
+			name:       "fall and rise",
+			alive:      true,
+			healthy:    1,
+			unhealthy:  2,
+			results:    []bool{false, false, true},
+			wantAlive:  true,
+			wantEvents: []string{register.BackendRemovedEvent, register.BackendAddedEvent},
+		},
+		{
+			// the outlier detection adds ejected backends back
+			name:      "rise while ejected",
+			ejected:   true,
+			results:   []bool{true, true},
+			wantAlive: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFakeRegister()
+			c, err := NewHealthChecker(registry.NewRegistry(), r, &Config{
+				HealthyThreshold:   tt.healthy,
+				UnhealthyThreshold: tt.unhealthy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := backend.NewDefaultBackend("backend", "http://127.0.0.1:8080")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.SetAlive(tt.alive)
+			b.SetEjected(tt.ejected)
+			c.states[b.ID] = &probeState{}
+
+			for _, healthy := range tt.results {
+				c.record(b, healthy)
+			}
+
+			if b.IsAlive() != tt.wantAlive {
+				t.Errorf("alive = %v, want %v", b.IsAlive(), tt.wantAlive)
+			}
+			events := r.drain()
+			if len(events) != len(tt.wantEvents) {
+				t.Fatalf("events = %v, want %v", events, tt.wantEvents)
+			}
+			for i := range events {
+				if events[i] != tt.wantEvents[i] {
+					t.Errorf("events = %v, want %v", events, tt.wantEvents)
+				}
+			}
+		})
+	}
+}
+
+// blockingProber blocks its probes until release is closed.
+type blockingProber struct {
+	probes  int32
+	release chan struct{}
+}
+
+func (p *blockingProber) Probe(ctx context.Context, b *backend.Backend) error {
+	atomic.AddInt32(&p.probes, 1)
+	select {
+	case <-p.release:
+		return nil
+	case <-ctx.Done():
+		return errors.New("probe timed out")
+	}
+}
+
+func TestCheckerSkipsInFlightProbes(t *testing.T) {
+	backendRegistry := registry.NewRegistry()
+	for _, id := range []string{"a", "b"} {
+		if err := backendRegistry.AddBackend(id, "http://127.0.0.1:8080", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := NewHealthChecker(backendRegistry, newFakeRegister(), &Config{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prober := &blockingProber{release: make(chan struct{})}
+	c.prober = prober
+
+	jobs := make(chan *backend.Backend)
+	go c.work(jobs)
+	go c.work(jobs)
+
+	// the second sweep skips the backends whose probes are still running
+	c.checkBackendLiveness(jobs)
+	c.checkBackendLiveness(jobs)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&prober.probes) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("probes not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&prober.probes); got != 2 {
+		t.Errorf("%d probes started, want 2", got)
+	}
+	close(prober.release)
+}
@@ -0,0 +1,283 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+)
+
+const (
+	TypeTCP   = "tcp"
+	TypeHTTP  = "http"
+	TypeHTTPS = "https"
+
+	// maxBodyBytes is the maximum size of a response body matched by HTTP probes.
+	maxBodyBytes = 64 << 10
+)
+
+var (
+	ErrUnknownType    = errors.New("unknown health check type")
+	ErrInvalidStatus  = errors.New("invalid expected status")
+	ErrUnexpectedBody = errors.New("unexpected health check response body")
+)
+
+// Config is the configuration of the health check of a pool.
+type Config struct {
+	// Interval is the time between the checks of a backend, 2s if zero, and
+	// Timeout is the time after which a check fails, 1s if zero.
+	Interval time.Duration `mapstructure:"interval"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	// HealthyThreshold and UnhealthyThreshold are the numbers of consecutive
+	// successful and failed checks which make a backend healthy and unhealthy,
+	// 2 and 3 if zero.
+	HealthyThreshold   int `mapstructure:"healthy-threshold"`
+	UnhealthyThreshold int `mapstructure:"unhealthy-threshold"`
+	// InitialDelay is the time before the first checks.
+	InitialDelay time.Duration `mapstructure:"initial-delay"`
+	// Jitter is the maximum random delay of the probe of each backend within
+	// an interval, which spreads the probes of the backends.
+	Jitter time.Duration `mapstructure:"jitter"`
+	// Concurrency is the maximum number of checks running at once, 8 if zero.
+	Concurrency int `mapstructure:"concurrency"`
+
+	// Type is the type of the health check, one of tcp, http, https, grpc (h2c)
+	// and grpcs (TLS). It is tcp if empty.
+	Type string `mapstructure:"type"`
+
+	// Path, Method and Headers are the request of HTTP checks. The path is "/"
+	// and the method is GET if empty.
+	Path    string            `mapstructure:"path"`
+	Method  string            `mapstructure:"method"`
+	Headers map[string]string `mapstructure:"headers"`
+	// Host overrides the Host header of HTTP and gRPC checks, and the server
+	// name of TLS checks.
+	Host string `mapstructure:"host"`
+	// InsecureSkipVerify skips the verification of the certificates of TLS checks.
+	InsecureSkipVerify bool `mapstructure:"insecure-skip-verify"`
+
+	// ExpectedStatuses are the healthy statuses or ranges of statuses, such as
+	// "200-299" or "204". It is 200-299 if empty.
+	ExpectedStatuses []string `mapstructure:"expected-statuses"`
+	// BodyContains and BodyRegex are matched against the response body if not empty.
+	BodyContains string `mapstructure:"body-contains"`
+	BodyRegex    string `mapstructure:"body-regex"`
+
+	// Service is the service name of gRPC checks, the whole server if empty.
+	Service string `mapstructure:"service"`
+}
+
+// Prober checks the health of a backend. Probe returns nil if the backend is healthy.
+type Prober interface {
+	Probe(ctx context.Context, b *backend.Backend) error
+}
+
+// NewProber creates the prober of the configured type. TLS probes connect with
+// the TLS configuration of the transport, if any, so that they trust the same
+// CAs and present the same client certificate as the requests to the backends.
+func NewProber(config *Config, transport *backend.Transport) (Prober, error) {
+	switch config.Type {
+	case "", TypeTCP:
+		return &TCPProber{}, nil
+	case TypeHTTP, TypeHTTPS:
+		return NewHTTPProber(config, transport)
+	case TypeGRPC, TypeGRPCS:
+		return NewGRPCProber(config, transport), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownType, config.Type)
+}
+
+// TCPProber considers a backend healthy if it accepts TCP connections.
+type TCPProber struct{}
+
+func (p *TCPProber) Probe(ctx context.Context, b *backend.Backend) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, TCP, b.Addr.Host)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// statusRange is an inclusive range of HTTP statuses.
+type statusRange struct {
+	min int
+	max int
+}
+
+// HTTPProber considers a backend healthy if it responds to the health check
+// request with an expected status and a matching body.
+type HTTPProber struct {
+	scheme  string
+	path    string
+	method  string
+	headers map[string]string
+	host    string
+
+	statuses     []statusRange
+	bodyContains string
+	bodyRegex    *regexp.Regexp
+
+	client *http.Client
+}
+
+func NewHTTPProber(config *Config, transport *backend.Transport) (*HTTPProber, error) {
+	statuses, err := parseStatuses(config.ExpectedStatuses)
+	if err != nil {
+		return nil, err
+	}
+
+	var bodyRegex *regexp.Regexp
+	if config.BodyRegex != "" {
+		bodyRegex, err = regexp.Compile(config.BodyRegex)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	path := config.Path
+	if path == "" {
+		path = "/"
+	}
+	method := config.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	// the probes don't share the connections of the transport, whose
+	// connection limit could hold them back
+	probeTransport := http.DefaultTransport.(*http.Transport).Clone()
+	probeTransport.TLSClientConfig = newProbeTLSConfig(config, transport)
+	// probe with a new connection each time, as a new client would connect
+	probeTransport.DisableKeepAlives = true
+
+	return &HTTPProber{
+		scheme:  config.Type,
+		path:    path,
+		method:  strings.ToUpper(method),
+		headers: config.Headers,
+		host:    config.Host,
+
+		statuses:     statuses,
+		bodyContains: config.BodyContains,
+		bodyRegex:    bodyRegex,
+
+		client: &http.Client{
+			Transport: probeTransport,
+			// report redirects as they are, so that 3xx can be expected or not
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+func (p *HTTPProber) Probe(ctx context.Context, b *backend.Backend) error {
+	url := fmt.Sprintf("%s://%s%s", p.scheme, b.Addr.Host, p.path)
+	req, err := http.NewRequestWithContext(ctx, p.method, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+	if p.host != "" {
+		req.Host = p.host
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if !p.expectedStatus(res.StatusCode) {
+		return fmt.Errorf("unexpected health check status: %d", res.StatusCode)
+	}
+
+	if p.bodyContains == "" && p.bodyRegex == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxBodyBytes))
+	if err != nil {
+		return err
+	}
+	if p.bodyContains != "" && !strings.Contains(string(body), p.bodyContains) {
+		return ErrUnexpectedBody
+	}
+	if p.bodyRegex != nil && !p.bodyRegex.Match(body) {
+		return ErrUnexpectedBody
+	}
+
+	return nil
+}
+
+// newProbeTLSConfig returns the TLS configuration of the probes, which is the
+// one of the transport if it uses TLS, with the host and verification of the
+// health check if set.
+func newProbeTLSConfig(config *Config, transport *backend.Transport) *tls.Config {
+	tlsConfig := &tls.Config{}
+	if transport != nil && transport.TLSConfig() != nil {
+		tlsConfig = transport.TLSConfig().Clone()
+	}
+	if config.Host != "" {
+		tlsConfig.ServerName = config.Host
+	}
+	if config.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig
+}
+
+func (p *HTTPProber) expectedStatus(status int) bool {
+	for _, r := range p.statuses {
+		if status >= r.min && status <= r.max {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseStatuses parses statuses and ranges of statuses such as "200-299".
+func parseStatuses(specs []string) ([]statusRange, error) {
+	if len(specs) == 0 {
+		return []statusRange{{min: 200, max: 299}}, nil
+	}
+
+	var statuses []statusRange
+	for _, spec := range specs {
+		low, high, isRange := strings.Cut(spec, "-")
+		if !isRange {
+			high = low
+		}
+
+		min, err := strconv.Atoi(strings.TrimSpace(low))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidStatus, spec)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(high))
+		if err != nil || max < min {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidStatus, spec)
+		}
+
+		statuses = append(statuses, statusRange{min: min, max: max})
+	}
+
+	return statuses, nil
+}
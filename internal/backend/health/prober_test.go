@@ -0,0 +1,263 @@
+package health
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+)
+
+func TestParseStatuses(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []statusRange
+		wantErr bool
+	}{
+		{name: "default", want: []statusRange{{min: 200, max: 299}}},
+		{name: "single status", specs: []string{"204"}, want: []statusRange{{min: 204, max: 204}}},
+		{name: "range", specs: []string{"200-399"}, want: []statusRange{{min: 200, max: 399}}},
+		{name: "spaces", specs: []string{" 200 - 204 "}, want: []statusRange{{min: 200, max: 204}}},
+		{name: "several", specs: []string{"200-299", "304"}, want: []statusRange{{min: 200, max: 299}, {min: 304, max: 304}}},
+		{name: "not a number", specs: []string{"ok"}, wantErr: true},
+		{name: "reversed range", specs: []string{"299-200"}, wantErr: true},
+		{name: "open range", specs: []string{"200-"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStatuses(tt.specs)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidStatus) {
+					t.Errorf("parseStatuses(%q) error = %v, want %v", tt.specs, err, ErrInvalidStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseStatuses(%q) = %v, want %v", tt.specs, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parseStatuses(%q) = %v, want %v", tt.specs, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPProber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/healthz":
+			_, _ = rw.Write([]byte(`{"status": "ok"}`))
+		case "/unavailable":
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		case "/redirect":
+			http.Redirect(rw, req, "/healthz", http.StatusFound)
+		case "/echo":
+			if req.Method != http.MethodHead || req.Host != "api.yorkie.dev" || req.Header.Get("X-Health-Check") != "l7" {
+				rw.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "default status", config: Config{Path: "/healthz"}},
+		{name: "unexpected status", config: Config{Path: "/unavailable"}, wantErr: true},
+		{name: "expected status", config: Config{Path: "/unavailable", ExpectedStatuses: []string{"503"}}},
+		{name: "redirect not followed", config: Config{Path: "/redirect"}, wantErr: true},
+		{name: "redirect expected", config: Config{Path: "/redirect", ExpectedStatuses: []string{"300-399"}}},
+		{name: "body contains", config: Config{Path: "/healthz", BodyContains: `"ok"`}},
+		{name: "body doesn't contain", config: Config{Path: "/healthz", BodyContains: "degraded"}, wantErr: true},
+		{name: "body matches", config: Config{Path: "/healthz", BodyRegex: `"status":\s*"ok"`}},
+		{name: "body doesn't match", config: Config{Path: "/healthz", BodyRegex: `^ok$`}, wantErr: true},
+		{
+			name: "method, host and headers",
+			config: Config{
+				Path:    "/echo",
+				Method:  "head",
+				Host:    "api.yorkie.dev",
+				Headers: map[string]string{"X-Health-Check": "l7"},
+			},
+		},
+	}
+
+	b, err := backend.NewDefaultBackend("backend", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Type = TypeHTTP
+			prober, err := NewHTTPProber(&tt.config, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := prober.Probe(ctx, b); (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPProberTLS(t *testing.T) {
+	clientCertFile, clientKeyFile := writeKeyPair(t, "client")
+
+	tests := []struct {
+		name       string
+		clientAuth bool
+		transport  func(caFile string) *backend.TransportConfig
+		config     Config
+		wantErr    bool
+	}{
+		{
+			name:      "CA of the pool",
+			transport: func(caFile string) *backend.TransportConfig { return transportConfig(caFile, "", "") },
+		},
+		{
+			name:      "no TLS configuration",
+			transport: func(caFile string) *backend.TransportConfig { return nil },
+			wantErr:   true,
+		},
+		{
+			name:      "insecure health check",
+			transport: func(caFile string) *backend.TransportConfig { return nil },
+			config:    Config{InsecureSkipVerify: true},
+		},
+		{
+			name:       "client certificate of the pool",
+			clientAuth: true,
+			transport: func(caFile string) *backend.TransportConfig {
+				return transportConfig(caFile, clientCertFile, clientKeyFile)
+			},
+		},
+		{
+			name:       "missing client certificate",
+			clientAuth: true,
+			transport:  func(caFile string) *backend.TransportConfig { return transportConfig(caFile, "", "") },
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+			if tt.clientAuth {
+				server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+			}
+			server.StartTLS()
+			defer server.Close()
+
+			var protocol string
+			config := tt.transport(writeCertificate(t, server.Certificate()))
+			if config != nil {
+				protocol = backend.ProtocolHTTPS
+			}
+			transport, err := backend.NewTransport(protocol, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := backend.NewBackend("backend", server.URL, protocol, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.config.Type = TypeHTTPS
+			prober, err := NewProber(&tt.config, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := prober.Probe(ctx, b); (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func transportConfig(caFile, certFile, keyFile string) *backend.TransportConfig {
+	return &backend.TransportConfig{
+		TLS: backend.TransportTLSConfig{
+			CAFile:   caFile,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+	}
+}
+
+// writeCertificate writes the certificate to a PEM file, and returns its path.
+func writeCertificate(t *testing.T, certificate *x509.Certificate) string {
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+// writeKeyPair writes a self-signed certificate of the common name and its key
+// to PEM files, and returns their paths.
+func writeKeyPair(t *testing.T, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLatencyDecay is the time window over which latency samples are averaged.
+	DefaultLatencyDecay = 10 * time.Second
+
+	// defaultLatency is used as the latency of a backend until it serves its first request,
+	// so that a new backend is not flooded before its latency is known.
+	defaultLatency = 10 * time.Millisecond
+
+	// errorLatencyPenalty is recorded as the latency of a request that failed.
+	errorLatencyPenalty = time.Second
+)
+
+// PeakEWMA is an exponentially weighted moving average of latency which
+// jumps to the peak whenever a sample is higher than the current average.
+// It reacts immediately to latency spikes and slowly decays back afterwards.
+type PeakEWMA struct {
+	mutex sync.Mutex
+	decay float64
+	value float64
+	stamp time.Time
+}
+
+func NewPeakEWMA(decay time.Duration) *PeakEWMA {
+	return &PeakEWMA{
+		decay: float64(decay),
+		value: float64(defaultLatency),
+		stamp: time.Now(),
+	}
+}
+
+// Observe records the given latency sample.
+func (e *PeakEWMA) Observe(latency time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+	sample := float64(latency)
+	if sample > e.value {
+		e.value = sample
+	} else {
+		w := math.Exp(-float64(now.Sub(e.stamp)) / e.decay)
+		e.value = e.value*w + sample*(1-w)
+	}
+	e.stamp = now
+}
+
+// Value returns the current average latency. Without new samples, the average
+// decays towards the default latency so that a backend which was avoided after
+// a spike is eventually tried again.
+func (e *PeakEWMA) Value() time.Duration {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	w := math.Exp(-float64(time.Since(e.stamp)) / e.decay)
+	return time.Duration(e.value*w + float64(defaultLatency)*(1-w))
+}
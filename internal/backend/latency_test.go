@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeakEWMA(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		// the average after the samples is within [min, max]
+		min, max time.Duration
+	}{
+		{name: "no samples", min: defaultLatency, max: defaultLatency},
+		{name: "peak", samples: []time.Duration{500 * time.Millisecond}, min: 499 * time.Millisecond, max: 500 * time.Millisecond},
+		{name: "highest peak", samples: []time.Duration{200 * time.Millisecond, 500 * time.Millisecond}, min: 499 * time.Millisecond, max: 500 * time.Millisecond},
+		// lower samples right after a peak barely move the average
+		{name: "lower sample after a peak", samples: []time.Duration{500 * time.Millisecond, time.Millisecond}, min: 400 * time.Millisecond, max: 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewPeakEWMA(DefaultLatencyDecay)
+			for _, sample := range tt.samples {
+				e.Observe(sample)
+			}
+
+			if got := e.Value(); got < tt.min || got > tt.max {
+				t.Errorf("Value() = %s, want within [%s, %s]", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestPeakEWMADecay(t *testing.T) {
+	e := NewPeakEWMA(10 * time.Millisecond)
+	e.Observe(time.Second)
+
+	// without samples, the average decays back to the default latency
+	time.Sleep(100 * time.Millisecond)
+	if got := e.Value(); got > defaultLatency+time.Millisecond {
+		t.Errorf("Value() = %s after the decay, want about %s", got, defaultLatency)
+	}
+
+	// lower samples pull the average down over time
+	e.Observe(time.Second)
+	time.Sleep(20 * time.Millisecond)
+	e.Observe(time.Millisecond)
+	if got := e.Value(); got >= time.Second || got <= time.Millisecond {
+		t.Errorf("Value() = %s, want between the samples", got)
+	}
+}
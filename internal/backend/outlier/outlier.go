@@ -0,0 +1,331 @@
+package outlier
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/register"
+	"github.com/krapie/l7/internal/backend/registry"
+)
+
+const (
+	DefaultInterval           = 10 * time.Second
+	DefaultBaseEjectionTime   = 30 * time.Second
+	DefaultMaxEjectionTime    = 5 * time.Minute
+	DefaultMaxEjectionPercent = 10
+
+	// minLatencyBackends is the minimum number of available backends for their
+	// median latency to tell latency outliers apart.
+	minLatencyBackends = 3
+)
+
+var (
+	ejections = expvar.NewMap("l7_outlier_ejections")
+)
+
+// Config is the configuration of the outlier detection of a pool, which is
+// disabled unless one of the consecutive errors or the latency factor is set.
+type Config struct {
+	// Consecutive5xx ejects a backend after this many consecutive 5xx responses
+	// or connection failures.
+	Consecutive5xx int `mapstructure:"consecutive-5xx"`
+	// ConsecutiveGatewayErrors ejects a backend after this many consecutive 502,
+	// 503 and 504 responses or connection failures.
+	ConsecutiveGatewayErrors int `mapstructure:"consecutive-gateway-errors"`
+	// LatencyFactor ejects a backend whose latency is this many times the median
+	// latency of the pool, checked every interval.
+	LatencyFactor float64       `mapstructure:"latency-factor"`
+	Interval      time.Duration `mapstructure:"interval"`
+
+	// A backend is ejected for the base ejection time, doubled on each
+	// consecutive ejection up to the max ejection time.
+	BaseEjectionTime time.Duration `mapstructure:"base-ejection-time"`
+	MaxEjectionTime  time.Duration `mapstructure:"max-ejection-time"`
+	// MaxEjectionPercent caps the share of ejected backends, though one
+	// backend can always be ejected. It is 10 if zero.
+	MaxEjectionPercent int `mapstructure:"max-ejection-percent"`
+}
+
+// Enabled returns whether outlier detection is configured.
+func (c *Config) Enabled() bool {
+	return c.Consecutive5xx > 0 || c.ConsecutiveGatewayErrors > 0 || c.LatencyFactor > 0
+}
+
+// Detector watches the results of proxied requests and the latency of the
+// backends of a pool, and temporarily ejects the backends failing requests
+// even though they pass active health checks.
+type Detector struct {
+	backendRegistry *registry.BackendRegistry
+	backendRegister register.Register
+
+	consecutive5xx           int
+	consecutiveGatewayErrors int
+	latencyFactor            float64
+	interval                 time.Duration
+	baseEjectionTime         time.Duration
+	maxEjectionTime          time.Duration
+	maxEjectionPercent       int
+
+	mutex  sync.Mutex
+	states map[string]*backendState
+
+	// events are the backend events queued for the register, sent in order by
+	// a single goroutine so that callers holding the mutex don't block.
+	eventsMutex sync.Mutex
+	events      []register.BackendEvent
+	eventsReady chan struct{}
+}
+
+type backendState struct {
+	consecutive5xx           int
+	consecutiveGatewayErrors int
+
+	// ejections is the number of consecutive ejections, which is reset once
+	// the backend stays healthy for the max ejection time.
+	ejections   int
+	ejected     bool
+	unejectedAt time.Time
+	// unejectTimer returns the backend once its ejection time is over.
+	unejectTimer *time.Timer
+}
+
+func NewDetector(registry *registry.BackendRegistry, register register.Register, config *Config) *Detector {
+	d := &Detector{
+		backendRegistry: registry,
+		backendRegister: register,
+
+		consecutive5xx:           config.Consecutive5xx,
+		consecutiveGatewayErrors: config.ConsecutiveGatewayErrors,
+		latencyFactor:            config.LatencyFactor,
+		interval:                 config.Interval,
+		baseEjectionTime:         config.BaseEjectionTime,
+		maxEjectionTime:          config.MaxEjectionTime,
+		maxEjectionPercent:       config.MaxEjectionPercent,
+
+		states:      make(map[string]*backendState),
+		eventsReady: make(chan struct{}, 1),
+	}
+	if d.interval <= 0 {
+		d.interval = DefaultInterval
+	}
+	if d.baseEjectionTime <= 0 {
+		d.baseEjectionTime = DefaultBaseEjectionTime
+	}
+	if d.maxEjectionTime <= 0 {
+		d.maxEjectionTime = DefaultMaxEjectionTime
+	}
+	if d.maxEjectionPercent <= 0 {
+		d.maxEjectionPercent = DefaultMaxEjectionPercent
+	}
+
+	go d.sendEvents()
+
+	return d
+}
+
+// Run starts checking the latency of the backends.
+func (d *Detector) Run() {
+	if d.latencyFactor <= 0 {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(d.interval)
+		for range t.C {
+			d.checkLatency()
+		}
+	}()
+}
+
+// Observe records the result of a request proxied to the backend, and ejects
+// the backend once its consecutive errors reach a threshold.
+func (d *Detector) Observe(b *backend.Backend, result backend.Result) {
+	failed := result.Err != nil || result.Status >= http.StatusInternalServerError
+	gatewayError := result.Err != nil || result.Status == http.StatusBadGateway ||
+		result.Status == http.StatusServiceUnavailable || result.Status == http.StatusGatewayTimeout
+
+	// the requests still in flight to a removed backend don't bring back its state
+	if _, ok := d.backendRegistry.GetBackendByID(b.ID); !ok {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	state := d.state(b.ID)
+	if failed {
+		state.consecutive5xx++
+	} else {
+		state.consecutive5xx = 0
+	}
+	if gatewayError {
+		state.consecutiveGatewayErrors++
+	} else {
+		state.consecutiveGatewayErrors = 0
+	}
+
+	switch {
+	case d.consecutive5xx > 0 && state.consecutive5xx >= d.consecutive5xx:
+		d.eject(b, state, "consecutive 5xx")
+	case d.consecutiveGatewayErrors > 0 && state.consecutiveGatewayErrors >= d.consecutiveGatewayErrors:
+		d.eject(b, state, "consecutive gateway errors")
+	}
+}
+
+// checkLatency ejects the backends whose latency is an outlier of the pool.
+func (d *Detector) checkLatency() {
+	var available []*backend.Backend
+	var latencies []time.Duration
+	for _, b := range d.backendRegistry.GetBackends() {
+		if b.IsAvailable() {
+			available = append(available, b)
+			latencies = append(latencies, b.Latency())
+		}
+	}
+	if len(available) < minLatencyBackends {
+		return
+	}
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	threshold := time.Duration(float64(latencies[len(latencies)/2]) * d.latencyFactor)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, b := range available {
+		if b.Latency() > threshold {
+			d.eject(b, d.state(b.ID), "latency outlier")
+		}
+	}
+}
+
+// eject ejects the backend unless it is already ejected or the max ejection
+// percent is reached. It must be called with the mutex held.
+func (d *Detector) eject(b *backend.Backend, state *backendState, reason string) {
+	if state.ejected {
+		return
+	}
+
+	backends := d.backendRegistry.GetBackends()
+	ejected := 0
+	for _, other := range backends {
+		if other.IsEjected() {
+			ejected++
+		}
+	}
+	maxEjected := len(backends) * d.maxEjectionPercent / 100
+	if maxEjected < 1 {
+		maxEjected = 1
+	}
+	if ejected >= maxEjected {
+		log.Printf("[Outlier] Not ejecting backend %s (%s): %d of %d backends already ejected", b.ID, reason, ejected, len(backends))
+		return
+	}
+
+	if !state.unejectedAt.IsZero() && time.Since(state.unejectedAt) > d.maxEjectionTime {
+		state.ejections = 0
+	}
+	state.ejections++
+	state.ejected = true
+	state.consecutive5xx = 0
+	state.consecutiveGatewayErrors = 0
+
+	duration := d.baseEjectionTime
+	for i := 1; i < state.ejections && duration < d.maxEjectionTime; i++ {
+		duration *= 2
+	}
+	if duration > d.maxEjectionTime {
+		duration = d.maxEjectionTime
+	}
+
+	log.Printf("[Outlier] Ejecting backend %s for %s: %s", b.ID, duration, reason)
+	ejections.Add(b.ID, 1)
+	b.SetEjected(true)
+	d.notify(register.BackendRemovedEvent, b.ID)
+
+	state.unejectTimer = time.AfterFunc(duration, func() {
+		d.uneject(b, state)
+	})
+}
+
+func (d *Detector) uneject(b *backend.Backend, state *backendState) {
+	d.mutex.Lock()
+	// the backend was removed from the registry
+	if d.states[b.ID] != state {
+		d.mutex.Unlock()
+		return
+	}
+	state.ejected = false
+	state.unejectedAt = time.Now()
+	state.unejectTimer = nil
+	d.mutex.Unlock()
+
+	log.Printf("[Outlier] Returning backend %s", b.ID)
+	b.SetEjected(false)
+
+	// removed backends are not returned
+	if _, ok := d.backendRegistry.GetBackendByID(b.ID); ok && b.IsAlive() {
+		d.notify(register.BackendAddedEvent, b.ID)
+	}
+}
+
+// Forget drops the state of a backend removed from the registry.
+func (d *Detector) Forget(backendID string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if state, ok := d.states[backendID]; ok {
+		if state.unejectTimer != nil {
+			state.unejectTimer.Stop()
+		}
+		delete(d.states, backendID)
+	}
+}
+
+// notify queues the event without blocking the request or the caller holding the mutex.
+func (d *Detector) notify(eventType string, backendID string) {
+	d.eventsMutex.Lock()
+	d.events = append(d.events, register.BackendEvent{
+		EventType: eventType,
+		Actor:     backendID,
+	})
+	d.eventsMutex.Unlock()
+
+	select {
+	case d.eventsReady <- struct{}{}:
+	default:
+	}
+}
+
+// sendEvents sends the queued events to the register in the order they were
+// queued, so that an un-ejection never overtakes its ejection.
+func (d *Detector) sendEvents() {
+	for range d.eventsReady {
+		d.eventsMutex.Lock()
+		events := d.events
+		d.events = nil
+		d.eventsMutex.Unlock()
+
+		for _, event := range events {
+			d.backendRegister.GetEventChannel() <- event
+		}
+	}
+}
+
+// state returns the state of the backend. It must be called with the mutex held.
+func (d *Detector) state(backendID string) *backendState {
+	state, ok := d.states[backendID]
+	if !ok {
+		state = &backendState{}
+		d.states[backendID] = state
+	}
+
+	return state
+}
@@ -0,0 +1,261 @@
+package outlier
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/register"
+	"github.com/krapie/l7/internal/backend/registry"
+)
+
+// fakeRegister collects the events sent by the detector.
+type fakeRegister struct {
+	events chan register.BackendEvent
+}
+
+func newFakeRegister() *fakeRegister {
+	return &fakeRegister{events: make(chan register.BackendEvent, 100)}
+}
+
+func (r *fakeRegister) SetTargetFilter(string)                      {}
+func (r *fakeRegister) SetRegistry(*registry.BackendRegistry)       {}
+func (r *fakeRegister) GetEventChannel() chan register.BackendEvent { return r.events }
+func (r *fakeRegister) Initialize() error                           { return nil }
+func (r *fakeRegister) Observe()                                    {}
+
+// newTestRegistry returns a registry of n backends named b0, b1 and so on.
+func newTestRegistry(t *testing.T, n int, addr string) *registry.BackendRegistry {
+	backendRegistry := registry.NewRegistry()
+	for i := 0; i < n; i++ {
+		if err := backendRegistry.AddBackend(fmt.Sprintf("b%d", i), addr, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return backendRegistry
+}
+
+func TestDetectorObserve(t *testing.T) {
+	errRefused := errors.New("connection refused")
+
+	tests := []struct {
+		name        string
+		config      Config
+		results     []backend.Result
+		wantEjected bool
+	}{
+		{
+			name:        "consecutive 5xx",
+			config:      Config{Consecutive5xx: 3},
+			results:     []backend.Result{{Status: 500}, {Status: 502}, {Err: errRefused}},
+			wantEjected: true,
+		},
+		{
+			name:    "below consecutive 5xx",
+			config:  Config{Consecutive5xx: 3},
+			results: []backend.Result{{Status: 500}, {Status: 500}},
+		},
+		{
+			name:    "success resets 5xx",
+			config:  Config{Consecutive5xx: 3},
+			results: []backend.Result{{Status: 500}, {Status: 500}, {Status: 200}, {Status: 500}, {Status: 500}},
+		},
+		{
+			name:    "4xx is not an error",
+			config:  Config{Consecutive5xx: 2},
+			results: []backend.Result{{Status: 404}, {Status: 429}, {Status: 400}},
+		},
+		{
+			name:        "consecutive gateway errors",
+			config:      Config{ConsecutiveGatewayErrors: 3},
+			results:     []backend.Result{{Status: 502}, {Status: 503}, {Status: 504}},
+			wantEjected: true,
+		},
+		{
+			name:    "500 is not a gateway error",
+			config:  Config{ConsecutiveGatewayErrors: 2},
+			results: []backend.Result{{Status: 502}, {Status: 500}, {Status: 503}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendRegistry := newTestRegistry(t, 1, "http://127.0.0.1:8080")
+			tt.config.MaxEjectionPercent = 100
+			r := newFakeRegister()
+			d := NewDetector(backendRegistry, r, &tt.config)
+
+			b, _ := backendRegistry.GetBackendByID("b0")
+			for _, result := range tt.results {
+				d.Observe(b, result)
+			}
+
+			if b.IsEjected() != tt.wantEjected {
+				t.Errorf("ejected = %v, want %v", b.IsEjected(), tt.wantEjected)
+			}
+			if tt.wantEjected {
+				if event := <-r.events; event.EventType != register.BackendRemovedEvent || event.Actor != b.ID {
+					t.Errorf("event = %v, want removal of %s", event, b.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectorMaxEjectionPercent(t *testing.T) {
+	tests := []struct {
+		name        string
+		backends    int
+		percent     int
+		wantEjected int
+	}{
+		// one backend can always be ejected
+		{name: "default percent", backends: 3, wantEjected: 1},
+		{name: "half", backends: 4, percent: 50, wantEjected: 2},
+		{name: "all", backends: 4, percent: 100, wantEjected: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendRegistry := newTestRegistry(t, tt.backends, "http://127.0.0.1:8080")
+			d := NewDetector(backendRegistry, newFakeRegister(), &Config{
+				Consecutive5xx:     1,
+				MaxEjectionPercent: tt.percent,
+			})
+
+			for _, b := range backendRegistry.GetBackends() {
+				d.Observe(b, backend.Result{Status: http.StatusInternalServerError})
+			}
+
+			ejected := 0
+			for _, b := range backendRegistry.GetBackends() {
+				if b.IsEjected() {
+					ejected++
+				}
+			}
+			if ejected != tt.wantEjected {
+				t.Errorf("%d backends ejected, want %d", ejected, tt.wantEjected)
+			}
+		})
+	}
+}
+
+func TestDetectorEjectionBackoff(t *testing.T) {
+	const base = 100 * time.Millisecond
+	backendRegistry := newTestRegistry(t, 1, "http://127.0.0.1:8080")
+	r := newFakeRegister()
+	d := NewDetector(backendRegistry, r, &Config{
+		Consecutive5xx:     1,
+		BaseEjectionTime:   base,
+		MaxEjectionTime:    3 * base,
+		MaxEjectionPercent: 100,
+	})
+	b, _ := backendRegistry.GetBackendByID("b0")
+
+	// the ejection time doubles on each consecutive ejection up to the max
+	for i, want := range []time.Duration{base, 2 * base, 3 * base, 3 * base} {
+		start := time.Now()
+		d.Observe(b, backend.Result{Status: http.StatusInternalServerError})
+		if !b.IsEjected() {
+			t.Fatalf("ejection %d: backend not ejected", i+1)
+		}
+
+		for b.IsEjected() {
+			if time.Since(start) > 10*base {
+				t.Fatalf("ejection %d: backend not returned", i+1)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if elapsed := time.Since(start); elapsed < want || elapsed > want+base/2+50*time.Millisecond {
+			t.Errorf("ejection %d lasted %s, want %s", i+1, elapsed, want)
+		}
+	}
+
+	// the removals and returns are sent in order
+	for i := 0; i < 8; i++ {
+		want := register.BackendRemovedEvent
+		if i%2 == 1 {
+			want = register.BackendAddedEvent
+		}
+		if event := <-r.events; event.EventType != want {
+			t.Fatalf("event %d = %s, want %s", i, event.EventType, want)
+		}
+	}
+}
+
+func TestDetectorForget(t *testing.T) {
+	backendRegistry := newTestRegistry(t, 1, "http://127.0.0.1:8080")
+	r := newFakeRegister()
+	d := NewDetector(backendRegistry, r, &Config{
+		Consecutive5xx:     1,
+		BaseEjectionTime:   50 * time.Millisecond,
+		MaxEjectionPercent: 100,
+	})
+	b, _ := backendRegistry.GetBackendByID("b0")
+
+	d.Observe(b, backend.Result{Status: http.StatusInternalServerError})
+	<-r.events
+	backendRegistry.RemoveBackendByID(b.ID)
+	d.Forget(b.ID)
+
+	// a removed backend is not returned, nor tracked again by late results
+	time.Sleep(100 * time.Millisecond)
+	d.Observe(b, backend.Result{Status: http.StatusInternalServerError})
+	select {
+	case event := <-r.events:
+		t.Errorf("event %v of a removed backend", event)
+	default:
+	}
+	if len(d.states) != 0 {
+		t.Errorf("states of removed backends = %v", d.states)
+	}
+}
+
+func TestDetectorCheckLatency(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		name        string
+		fast        int
+		wantEjected bool
+	}{
+		{name: "outlier", fast: 2, wantEjected: true},
+		// the median of two backends doesn't tell which one is the outlier
+		{name: "too few backends", fast: 1, wantEjected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendRegistry := newTestRegistry(t, tt.fast, fast.URL)
+			if err := backendRegistry.AddBackend("slow", slow.URL, 1); err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range backendRegistry.GetBackends() {
+				b.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+
+			d := NewDetector(backendRegistry, newFakeRegister(), &Config{
+				LatencyFactor:      3,
+				MaxEjectionPercent: 100,
+			})
+			d.checkLatency()
+
+			for _, b := range backendRegistry.GetBackends() {
+				want := tt.wantEjected && b.ID == "slow"
+				if b.IsEjected() != want {
+					t.Errorf("backend %s ejected = %v, want %v", b.ID, b.IsEjected(), want)
+				}
+			}
+		})
+	}
+}
@@ -68,6 +68,7 @@ func (r *Register) Initialize() error {
 		err = r.ServiceRegistry.AddBackend(
 			c.ID,
 			fmt.Sprintf("%s://%s:%d", register.SCHEME, register.IP, c.Ports[0].PublicPort),
+			register.ParseWeight(c.Labels),
 		)
 		if err != nil {
 			return err
@@ -120,6 +121,7 @@ func (r *Register) observe() {
 				err = r.ServiceRegistry.AddBackend(
 					c[0].ID,
 					fmt.Sprintf("%s://%s:%d", register.SCHEME, c[0].Ports[0].IP, c[0].Ports[0].PublicPort),
+					register.ParseWeight(c[0].Labels),
 				)
 				if err != nil {
 					log.Printf("[Register] Error adding backend: %s", err)
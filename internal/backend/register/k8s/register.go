@@ -114,9 +114,16 @@ func (r *Register) observe() {
 				continue
 			}
 
+			weight := register.ParseWeight(pod.Annotations)
+			if _, exists := r.ServiceRegistry.GetBackendByID(pod.Name); exists {
+				r.updateWeight(pod.Name, weight)
+				continue
+			}
+
 			err = r.ServiceRegistry.AddBackend(
 				pod.Name,
 				fmt.Sprintf("%s://%s:%d", register.SCHEME, pod.Status.PodIP, pod.Spec.Containers[0].Ports[0].ContainerPort),
+				weight,
 			)
 			if err != nil {
 				// log.Printf("[Register] Error adding backend: %s", err)
@@ -144,3 +151,20 @@ func (r *Register) observe() {
 		}
 	}
 }
+
+func (r *Register) updateWeight(podName string, weight int) {
+	changed, err := r.ServiceRegistry.SetBackendWeight(podName, weight)
+	if err != nil {
+		log.Printf("[Register] Error updating backend weight: %s", err)
+		return
+	}
+	if !changed {
+		return
+	}
+
+	r.EventChannel <- register.BackendEvent{
+		EventType: register.BackendWeightChangedEvent,
+		Actor:     podName,
+	}
+	log.Printf("[Register] Backend Weight Changed: Hostname: %s, Weight: %d\n", podName, weight)
+}
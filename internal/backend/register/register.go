@@ -2,7 +2,10 @@ package register
 
 import (
 	"errors"
+	"log"
+	"strconv"
 
+	"github.com/krapie/l7/internal/backend"
 	"github.com/krapie/l7/internal/backend/registry"
 )
 
@@ -11,8 +14,12 @@ var (
 )
 
 const (
-	BackendAddedEvent   = "add"
-	BackendRemovedEvent = "remove"
+	BackendAddedEvent         = "add"
+	BackendRemovedEvent       = "remove"
+	BackendWeightChangedEvent = "weight"
+
+	// WeightKey is the Docker label or Kubernetes annotation holding the weight of a backend.
+	WeightKey = "l7.krapie.io/weight"
 
 	// TODO(krapie): we termporay use this image for testing, but we can make it configurable
 	SCHEME = "http"
@@ -24,6 +31,23 @@ type BackendEvent struct {
 	Actor     string
 }
 
+// ParseWeight parses the weight from the labels or annotations of a backend.
+// It returns the default weight if the weight is missing or invalid.
+func ParseWeight(labels map[string]string) int {
+	value, ok := labels[WeightKey]
+	if !ok {
+		return backend.DefaultWeight
+	}
+
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight <= 0 {
+		log.Printf("[Register] Invalid weight %q, using default weight", value)
+		return backend.DefaultWeight
+	}
+
+	return weight
+}
+
 type Register interface {
 	SetTargetFilter(targetFilter string)
 	SetRegistry(registry *registry.BackendRegistry)
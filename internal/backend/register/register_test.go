@@ -0,0 +1,31 @@
+package register
+
+import (
+	"testing"
+
+	"github.com/krapie/l7/internal/backend"
+)
+
+func TestParseWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   int
+	}{
+		{name: "no labels", want: backend.DefaultWeight},
+		{name: "no weight", labels: map[string]string{"app": "yorkie"}, want: backend.DefaultWeight},
+		{name: "weight", labels: map[string]string{WeightKey: "3"}, want: 3},
+		{name: "zero", labels: map[string]string{WeightKey: "0"}, want: backend.DefaultWeight},
+		{name: "negative", labels: map[string]string{WeightKey: "-2"}, want: backend.DefaultWeight},
+		{name: "not a number", labels: map[string]string{WeightKey: "heavy"}, want: backend.DefaultWeight},
+		{name: "empty", labels: map[string]string{WeightKey: ""}, want: backend.DefaultWeight},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseWeight(tt.labels); got != tt.want {
+				t.Errorf("ParseWeight(%v) = %d, want %d", tt.labels, got, tt.want)
+			}
+		})
+	}
+}
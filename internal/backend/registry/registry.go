@@ -2,6 +2,8 @@ package registry
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
 	"sync/atomic"
 
 	"github.com/krapie/l7/internal/backend"
@@ -10,19 +12,83 @@ import (
 var (
 	ErrIndexOutOfRange      = errors.New("index out of range")
 	ErrBackendAlreadyExists = errors.New("backend already exists")
+	ErrBackendNotFound      = errors.New("backend not found")
+	ErrInvalidWeight        = errors.New("weight must be positive")
 )
 
 type BackendRegistry struct {
 	Registry atomic.Value
+
+	// protocol and transport are used by the backends added to the registry.
+	protocol  string
+	transport *backend.Transport
+	// observer is notified of the results of the requests of the backends.
+	observer backend.ResultObserver
+	// circuitBreaker is the limits of each backend.
+	circuitBreaker backend.CircuitBreakerConfig
 }
 
 func NewRegistry() *BackendRegistry {
 	backendRegistry := atomic.Value{}
 	backendRegistry.Store([]*backend.Backend{})
 
+	// the default transport of HTTP/1.1 can't fail to be created
+	transport, _ := backend.NewTransport(backend.ProtocolHTTP1, nil)
+
 	return &BackendRegistry{
 		Registry: backendRegistry,
+
+		protocol:  backend.ProtocolHTTP1,
+		transport: transport,
+	}
+}
+
+// SetResultObserver sets the observer of the request results of the backends
+// added afterwards.
+func (s *BackendRegistry) SetResultObserver(observer backend.ResultObserver) {
+	s.observer = observer
+}
+
+// SetCircuitBreaker sets the limits of each backend added afterwards. It must
+// be called after SetProtocol, as the connection limit is set on the transport,
+// which only HTTP/1.1 transports can limit.
+func (s *BackendRegistry) SetCircuitBreaker(config backend.CircuitBreakerConfig) error {
+	t, ok := s.transport.RoundTripper.(*http.Transport)
+	if !ok && config.MaxConnections > 0 {
+		return fmt.Errorf("%w: %s", backend.ErrMaxConnectionsNotSupported, s.protocol)
+	}
+
+	s.circuitBreaker = config
+
+	// the transport limits the connections of each backend address, keeping
+	// the limit of the transport configuration if lower
+	if ok && config.MaxConnections > 0 && (t.MaxConnsPerHost <= 0 || config.MaxConnections < t.MaxConnsPerHost) {
+		t = t.Clone()
+		t.MaxConnsPerHost = config.MaxConnections
+		s.transport = &backend.Transport{RoundTripper: t, Upgrade: t}
+	}
+	return nil
+}
+
+// SetProtocol sets the protocol spoken by the backends added afterwards, and
+// the configuration of their connections, the defaults if nil.
+func (s *BackendRegistry) SetProtocol(protocol string, config *backend.TransportConfig) error {
+	transport, err := backend.NewTransport(protocol, config)
+	if err != nil {
+		return err
+	}
+
+	if protocol == "" {
+		protocol = backend.ProtocolHTTP1
 	}
+	s.protocol = protocol
+	s.transport = transport
+	return nil
+}
+
+// Transport returns the transport of the backends added to the registry.
+func (s *BackendRegistry) Transport() *backend.Transport {
+	return s.transport
 }
 
 func (s *BackendRegistry) GetBackends() []*backend.Backend {
@@ -48,21 +114,46 @@ func (s *BackendRegistry) GetBackendByIndex(index int64) (*backend.Backend, erro
 	return backends[index], nil
 }
 
-func (s *BackendRegistry) AddBackend(hostname, addr string) error {
+func (s *BackendRegistry) AddBackend(hostname, addr string, weight int) error {
 	if _, ok := s.GetBackendByID(hostname); ok {
 		return ErrBackendAlreadyExists
 	}
+	if weight <= 0 {
+		return ErrInvalidWeight
+	}
 
-	b, err := backend.NewDefaultBackend(hostname, addr)
+	b, err := backend.NewBackend(hostname, addr, s.protocol, s.transport)
 	if err != nil {
 		return err
 	}
+	b.SetWeight(weight)
+	b.SetResultObserver(s.observer)
+	b.SetCircuitBreaker(s.circuitBreaker)
 
 	s.Registry.Store(append(s.GetBackends(), b))
 
 	return nil
 }
 
+// SetBackendWeight changes the weight of the backend. It returns false if the
+// weight is unchanged.
+func (s *BackendRegistry) SetBackendWeight(ID string, weight int) (bool, error) {
+	if weight <= 0 {
+		return false, ErrInvalidWeight
+	}
+
+	b, ok := s.GetBackendByID(ID)
+	if !ok {
+		return false, ErrBackendNotFound
+	}
+	if b.GetWeight() == weight {
+		return false, nil
+	}
+
+	b.SetWeight(weight)
+	return true, nil
+}
+
 func (s *BackendRegistry) RemoveBackendByID(ID string) {
 	var backends []*backend.Backend
 	for _, b := range s.GetBackends() {
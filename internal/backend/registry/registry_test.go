@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/krapie/l7/internal/backend"
+)
+
+func TestAddBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		addr    string
+		weight  int
+		wantErr error
+	}{
+		{name: "new backend", id: "c", addr: "http://127.0.0.1:8082", weight: 2},
+		{name: "existing backend", id: "a", addr: "http://127.0.0.1:8082", weight: 1, wantErr: ErrBackendAlreadyExists},
+		{name: "zero weight", id: "c", addr: "http://127.0.0.1:8082", weight: 0, wantErr: ErrInvalidWeight},
+		{name: "negative weight", id: "c", addr: "http://127.0.0.1:8082", weight: -1, wantErr: ErrInvalidWeight},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			for _, id := range []string{"a", "b"} {
+				if err := r.AddBackend(id, "http://127.0.0.1:8080", 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := r.AddBackend(tt.id, tt.addr, tt.weight)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AddBackend() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if r.Len() != 2 {
+					t.Errorf("Len() = %d, want 2", r.Len())
+				}
+				return
+			}
+
+			b, ok := r.GetBackendByID(tt.id)
+			if !ok {
+				t.Fatalf("backend %s not found", tt.id)
+			}
+			if b.GetWeight() != tt.weight || b.Addr.Host != "127.0.0.1:8082" {
+				t.Errorf("backend = %s weight %d, want %s weight %d", b.Addr.Host, b.GetWeight(), tt.addr, tt.weight)
+			}
+			if last, _ := r.GetBackendByIndex(int64(r.Len() - 1)); last != b {
+				t.Errorf("last backend = %s, want %s", last.ID, b.ID)
+			}
+		})
+	}
+}
+
+func TestSetBackendWeight(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          string
+		weight      int
+		wantChanged bool
+		wantErr     error
+		wantWeight  int
+	}{
+		{name: "changed", id: "a", weight: 3, wantChanged: true, wantWeight: 3},
+		{name: "unchanged", id: "a", weight: 1, wantWeight: 1},
+		{name: "unknown backend", id: "c", weight: 3, wantErr: ErrBackendNotFound, wantWeight: 1},
+		{name: "zero weight", id: "a", weight: 0, wantErr: ErrInvalidWeight, wantWeight: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			if err := r.AddBackend("a", "http://127.0.0.1:8080", 1); err != nil {
+				t.Fatal(err)
+			}
+
+			changed, err := r.SetBackendWeight(tt.id, tt.weight)
+			if !errors.Is(err, tt.wantErr) || changed != tt.wantChanged {
+				t.Errorf("SetBackendWeight() = %v, %v, want %v, %v", changed, err, tt.wantChanged, tt.wantErr)
+			}
+
+			b, _ := r.GetBackendByID("a")
+			if b.GetWeight() != tt.wantWeight {
+				t.Errorf("weight = %d, want %d", b.GetWeight(), tt.wantWeight)
+			}
+		})
+	}
+}
+
+func TestRemoveBackendByID(t *testing.T) {
+	r := NewRegistry()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := r.AddBackend(id, "http://127.0.0.1:8080", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backends := r.GetBackends()
+
+	r.RemoveBackendByID("b")
+	r.RemoveBackendByID("unknown")
+
+	if r.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", r.Len())
+	}
+	if _, ok := r.GetBackendByID("b"); ok {
+		t.Error("removed backend found")
+	}
+	if _, err := r.GetBackendByIndex(2); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Errorf("GetBackendByIndex(2) error = %v, want %v", err, ErrIndexOutOfRange)
+	}
+	// the backends read before the removal are unchanged
+	if len(backends) != 3 || backends[1].ID != "b" {
+		t.Errorf("backends read before the removal changed")
+	}
+}
+
+func TestSetCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name                string
+		protocol            string
+		maxConnsPerHost     int
+		maxConnections      int
+		wantErr             error
+		wantMaxConnsPerHost int
+	}{
+		{name: "no limit", protocol: backend.ProtocolHTTP1},
+		{name: "limit", protocol: backend.ProtocolHTTP1, maxConnections: 10, wantMaxConnsPerHost: 10},
+		{name: "lower transport limit", protocol: backend.ProtocolHTTP1, maxConnsPerHost: 5, maxConnections: 10, wantMaxConnsPerHost: 5},
+		{name: "higher transport limit", protocol: backend.ProtocolHTTP1, maxConnsPerHost: 20, maxConnections: 10, wantMaxConnsPerHost: 10},
+		{name: "HTTP/2", protocol: backend.ProtocolH2C, maxConnections: 10, wantErr: backend.ErrMaxConnectionsNotSupported},
+		{name: "HTTP/2 without limit", protocol: backend.ProtocolH2C},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			if err := r.SetProtocol(tt.protocol, &backend.TransportConfig{MaxConnsPerHost: tt.maxConnsPerHost}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := r.SetCircuitBreaker(backend.CircuitBreakerConfig{MaxConnections: tt.maxConnections})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetCircuitBreaker() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			transport, ok := r.Transport().RoundTripper.(*http.Transport)
+			if !ok {
+				return
+			}
+			if transport.MaxConnsPerHost != tt.wantMaxConnsPerHost {
+				t.Errorf("MaxConnsPerHost = %d, want %d", transport.MaxConnsPerHost, tt.wantMaxConnsPerHost)
+			}
+			// upgrade requests share the limit
+			if r.Transport().Upgrade != transport {
+				t.Error("upgrade transport doesn't share the connection limit")
+			}
+		})
+	}
+}
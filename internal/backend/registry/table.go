@@ -1,6 +1,15 @@
 package registry
 
+// Table maps keys to backends for key-affinity load balancing. Implementations
+// trade off lookup speed, memory and how many keys move when backends change.
 type Table interface {
-	Add(backend string) error
+	Add(backend string, weight int) error
 	Remove(backend string) error
+	SetWeight(backend string, weight int) error
+
+	// Get returns the backend which owns the key.
+	Get(key string) (string, error)
+	// GetCandidates returns every backend in the order the key falls back to
+	// them, starting with the owner of the key.
+	GetCandidates(key string) ([]string, error)
 }
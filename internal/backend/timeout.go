@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+var (
+	// ErrResponseHeaderTimeout is the cause of the cancellation of the requests
+	// whose backend didn't respond with headers in time.
+	ErrResponseHeaderTimeout = errors.New("backend response header timeout")
+	// ErrRequestTimeout is the cause of the cancellation of the requests which
+	// didn't complete within the timeout of their route.
+	ErrRequestTimeout = errors.New("request timeout")
+	// ErrStreamIdleTimeout is the cause of the cancellation of the streams
+	// without traffic for the idle timeout of their route.
+	ErrStreamIdleTimeout = errors.New("stream idle timeout")
+)
+
+// Timeouts are the timeouts of the requests to backends, set per route.
+type Timeouts struct {
+	// Connect is the timeout of connecting to the backend, the dial timeout of
+	// the transport if zero.
+	Connect time.Duration
+	// ResponseHeader is the timeout of the backend responding with headers
+	// once the request is sent, unlimited if zero.
+	ResponseHeader time.Duration
+}
+
+// WithTimeouts returns a context setting the backend timeouts of its requests.
+func WithTimeouts(ctx context.Context, timeouts Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, timeouts)
+}
+
+func timeoutsOf(ctx context.Context) Timeouts {
+	timeouts, _ := ctx.Value(timeoutsKey{}).(Timeouts)
+	return timeouts
+}
+
+type timeoutsKey struct{}
+
+// IsTimeout returns whether the cause of a cancellation is a timeout of l7.
+func IsTimeout(cause error) bool {
+	return errors.Is(cause, ErrResponseHeaderTimeout) || errors.Is(cause, ErrRequestTimeout) ||
+		errors.Is(cause, ErrStreamIdleTimeout)
+}
+
+// dialFunc is the signature of the dial functions of transports.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// withConnectTimeout wraps the dial function to give up after the connect
+// timeout of the request of the connection, if set.
+func withConnectTimeout(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if timeout := timeoutsOf(ctx).Connect; timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return dial(ctx, network, addr)
+	}
+}
+
+// startResponseHeaderTimer cancels the request with ErrResponseHeaderTimeout
+// unless its response headers are received within the response header
+// timeout of the request, if set. The returned stop function must be called
+// once the request is served.
+func startResponseHeaderTimer(ctx context.Context) (context.Context, func()) {
+	timeout := timeoutsOf(ctx).ResponseHeader
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(timeout, func() {
+		cancel(ErrResponseHeaderTimeout)
+	})
+
+	return context.WithValue(ctx, responseHeaderTimerKey{}, timer), func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// stopResponseHeaderTimer stops the response header timer of the request
+// once its response headers are received.
+func stopResponseHeaderTimer(ctx context.Context) {
+	if timer, ok := ctx.Value(responseHeaderTimerKey{}).(*time.Timer); ok {
+		timer.Stop()
+	}
+}
+
+type responseHeaderTimerKey struct{}
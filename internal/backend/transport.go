@@ -0,0 +1,339 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// ProtocolHTTP1 sends requests over HTTP/1.1.
+	ProtocolHTTP1 = "http1"
+	// ProtocolHTTPS sends requests over HTTP/1.1 with TLS.
+	ProtocolHTTPS = "https"
+	// ProtocolH2 sends requests over HTTP/2 with TLS.
+	ProtocolH2 = "h2"
+	// ProtocolH2C sends requests over cleartext HTTP/2 with prior knowledge.
+	ProtocolH2C = "h2c"
+
+	DefaultDialTimeout         = 30 * time.Second
+	DefaultKeepAlive           = 30 * time.Second
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 32
+	DefaultIdleConnTimeout     = 90 * time.Second
+
+	// bufferSize is the size of the buffers copying response bodies, which is
+	// the size used by httputil.ReverseProxy.
+	bufferSize = 32 * 1024
+)
+
+var (
+	ErrUnknownProtocol    = errors.New("unknown backend protocol")
+	ErrTLSNotSupported    = errors.New("backend TLS requires the https or h2 protocol")
+	ErrIncompleteKeyPair  = errors.New("backend client certificate requires both cert-file and key-file")
+	ErrInvalidCertificate = errors.New("no certificate found in CA file")
+)
+
+// TransportConfig is the configuration of the connections of a pool to its
+// backends. Zero values are the defaults.
+type TransportConfig struct {
+	// DialTimeout is the timeout of connecting to a backend, 30s if zero. The
+	// connect timeout of a route takes precedence if shorter.
+	DialTimeout time.Duration `mapstructure:"dial-timeout"`
+	// KeepAlive is the interval of TCP keep-alive probes, 30s if zero and
+	// disabled if negative.
+	KeepAlive time.Duration `mapstructure:"keep-alive"`
+
+	// MaxIdleConns is the maximum number of idle connections to the backends
+	// of the pool (100 if zero), MaxIdleConnsPerHost to each backend (32 if
+	// zero), and MaxConnsPerHost the maximum number of connections to each
+	// backend (unlimited if zero). IdleConnTimeout closes idle connections
+	// after this long (90s if zero), and DisableKeepAlives sends every request
+	// on a new connection. They only apply to HTTP/1.1, as HTTP/2 multiplexes
+	// requests on a single connection per backend.
+	MaxIdleConns        int           `mapstructure:"max-idle-conns"`
+	MaxIdleConnsPerHost int           `mapstructure:"max-idle-conns-per-host"`
+	MaxConnsPerHost     int           `mapstructure:"max-conns-per-host"`
+	IdleConnTimeout     time.Duration `mapstructure:"idle-conn-timeout"`
+	DisableKeepAlives   bool          `mapstructure:"disable-keep-alives"`
+
+	// TLS is the configuration of the TLS connections of the https and h2 protocols.
+	TLS TransportTLSConfig `mapstructure:"tls"`
+}
+
+// TransportTLSConfig is the configuration of the TLS connections to backends.
+type TransportTLSConfig struct {
+	// CAFile is a PEM file of the CAs verifying backend certificates, the
+	// system roots if empty.
+	CAFile string `mapstructure:"ca-file"`
+	// CertFile and KeyFile are the PEM files of the client certificate sent to
+	// backends requiring mutual TLS. They are reloaded when they change, such
+	// as when a service mesh rotates them.
+	CertFile string `mapstructure:"cert-file"`
+	KeyFile  string `mapstructure:"key-file"`
+	// ServerName is the name verified in backend certificates instead of
+	// their address, such as the service name of the backends.
+	ServerName         string `mapstructure:"server-name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure-skip-verify"`
+}
+
+func (c *TransportTLSConfig) isSet() bool {
+	return *c != TransportTLSConfig{}
+}
+
+// Transport is the transport of the requests to the backends of a pool.
+// Backends of a pool share the transport, and thereby its connection pool.
+type Transport struct {
+	// RoundTripper sends requests in the protocol of the backends.
+	http.RoundTripper
+	// Upgrade sends upgrade requests over HTTP/1.1, the only protocol which can
+	// switch protocols, with the same configuration. It is the RoundTripper of
+	// HTTP/1.1 backends.
+	Upgrade *http.Transport
+}
+
+// NewTransport returns the transport of the requests to backends speaking the
+// protocol, HTTP/1.1 if empty, with the given configuration.
+func NewTransport(protocol string, config *TransportConfig) (*Transport, error) {
+	if config == nil {
+		config = &TransportConfig{}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: config.KeepAlive,
+	}
+	if dialer.Timeout <= 0 {
+		dialer.Timeout = DefaultDialTimeout
+	}
+	if dialer.KeepAlive == 0 {
+		dialer.KeepAlive = DefaultKeepAlive
+	}
+	// connect with the connect timeout of the route of the request, if set
+	dial := withConnectTimeout(dialer.DialContext)
+
+	var tlsConfig *tls.Config
+	switch protocol {
+	case ProtocolHTTPS, ProtocolH2:
+		var err error
+		if tlsConfig, err = newClientTLSConfig(&config.TLS); err != nil {
+			return nil, err
+		}
+	case "", ProtocolHTTP1, ProtocolH2C:
+		if config.TLS.isSet() {
+			return nil, fmt.Errorf("%w: %s", ErrTLSNotSupported, protocol)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProtocol, protocol)
+	}
+
+	upgrade := newHTTP1Transport(dial, tlsConfig, config)
+
+	switch protocol {
+	case ProtocolH2:
+		return &Transport{
+			RoundTripper: &http2.Transport{
+				TLSClientConfig: tlsConfig.Clone(),
+				DialTLSContext: func(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error) {
+					conn, err := dial(ctx, network, addr)
+					if err != nil {
+						return nil, err
+					}
+
+					tlsConn := tls.Client(conn, config)
+					if err = tlsConn.HandshakeContext(ctx); err != nil {
+						_ = conn.Close()
+						return nil, err
+					}
+					return tlsConn, nil
+				},
+			},
+			Upgrade: upgrade,
+		}, nil
+	case ProtocolH2C:
+		return &Transport{
+			RoundTripper: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dial(ctx, network, addr)
+				},
+			},
+			Upgrade: upgrade,
+		}, nil
+	}
+
+	return &Transport{RoundTripper: upgrade, Upgrade: upgrade}, nil
+}
+
+// TLSConfig returns the TLS configuration of the connections to the backends,
+// nil if they don't use TLS.
+func (t *Transport) TLSConfig() *tls.Config {
+	return t.Upgrade.TLSClientConfig
+}
+
+// newHTTP1Transport returns an HTTP/1.1 transport with the configuration,
+// over TLS if tlsConfig is not nil.
+func newHTTP1Transport(dial dialFunc, tlsConfig *tls.Config, config *TransportConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dial
+	transport.TLSClientConfig = tlsConfig
+	// https speaks HTTP/1.1 even to backends offering HTTP/2, which is h2
+	transport.ForceAttemptHTTP2 = false
+	transport.MaxIdleConns = config.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = config.MaxConnsPerHost
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	transport.DisableKeepAlives = config.DisableKeepAlives
+	if transport.IdleConnTimeout <= 0 {
+		transport.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if transport.MaxIdleConns <= 0 {
+		transport.MaxIdleConns = DefaultMaxIdleConns
+	}
+	// Go keeps 2 idle connections per host by default, which makes busy pools
+	// open and close connections until they run out of ephemeral ports
+	if transport.MaxIdleConnsPerHost <= 0 {
+		transport.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+
+	return transport
+}
+
+// newClientTLSConfig returns the TLS configuration of the connections to backends.
+func newClientTLSConfig(config *TransportTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read backend CA file: %w", err)
+		}
+
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCertificate, config.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, ErrIncompleteKeyPair
+		}
+
+		certificate := &clientCertificate{certFile: config.CertFile, keyFile: config.KeyFile}
+		if _, err := certificate.get(); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return certificate.get()
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// clientCertificate is a client certificate loaded from PEM files, which is
+// reloaded when the files change.
+type clientCertificate struct {
+	certFile string
+	keyFile  string
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+func (c *clientCertificate) get() (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	modTime, err := latestModTime(c.certFile, c.keyFile)
+	if err != nil {
+		if c.certificate != nil {
+			// keep the loaded certificate while the files are being replaced
+			return c.certificate, nil
+		}
+		return nil, err
+	}
+	if c.certificate != nil && !modTime.After(c.modTime) {
+		return c.certificate, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.certificate != nil {
+			return c.certificate, nil
+		}
+		return nil, fmt.Errorf("load backend client certificate: %w", err)
+	}
+
+	c.certificate = &certificate
+	c.modTime = modTime
+	return c.certificate, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// schemeOf returns the URL scheme of the backends speaking the protocol.
+func schemeOf(protocol string) string {
+	if protocol == ProtocolH2 || protocol == ProtocolHTTPS {
+		return "https"
+	}
+
+	return "http"
+}
+
+// bufferPool reuses the buffers copying response bodies across the requests
+// of every backend, rather than allocating one per request. It holds pointers
+// to the buffers, as putting a slice in a sync.Pool allocates. The pointers are
+// array pointers, which a slice converts back to without allocating.
+type bufferPool struct {
+	pool sync.Pool
+}
+
+var sharedBufferPool = &bufferPool{
+	pool: sync.Pool{
+		New: func() interface{} {
+			return new([bufferSize]byte)
+		},
+	},
+}
+
+func (p *bufferPool) Get() []byte {
+	return p.pool.Get().(*[bufferSize]byte)[:]
+}
+
+func (p *bufferPool) Put(buf []byte) {
+	if cap(buf) < bufferSize {
+		return
+	}
+
+	p.pool.Put((*[bufferSize]byte)(buf[:bufferSize]))
+}
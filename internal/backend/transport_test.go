@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestNewTransport(t *testing.T) {
+	invalidCAFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(invalidCAFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		protocol string
+		config   *TransportConfig
+		wantErr  error
+		wantTLS  bool
+		wantH2   bool
+	}{
+		{name: "default", protocol: ""},
+		{name: "http1", protocol: ProtocolHTTP1},
+		{name: "https", protocol: ProtocolHTTPS, wantTLS: true},
+		{name: "h2", protocol: ProtocolH2, wantTLS: true, wantH2: true},
+		{name: "h2c", protocol: ProtocolH2C, wantH2: true},
+		{name: "unknown protocol", protocol: "h3", wantErr: ErrUnknownProtocol},
+		{
+			name:     "TLS of cleartext protocol",
+			protocol: ProtocolH2C,
+			config:   &TransportConfig{TLS: TransportTLSConfig{ServerName: "api"}},
+			wantErr:  ErrTLSNotSupported,
+		},
+		{
+			name:     "certificate without key",
+			protocol: ProtocolHTTPS,
+			config:   &TransportConfig{TLS: TransportTLSConfig{CertFile: "client.pem"}},
+			wantErr:  ErrIncompleteKeyPair,
+		},
+		{
+			name:     "invalid CA file",
+			protocol: ProtocolHTTPS,
+			config:   &TransportConfig{TLS: TransportTLSConfig{CAFile: invalidCAFile}},
+			wantErr:  ErrInvalidCertificate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := NewTransport(tt.protocol, tt.config)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewTransport() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if (transport.TLSConfig() != nil) != tt.wantTLS {
+				t.Errorf("TLSConfig() = %v, want TLS %v", transport.TLSConfig(), tt.wantTLS)
+			}
+			if _, ok := transport.RoundTripper.(*http2.Transport); ok != tt.wantH2 {
+				t.Errorf("RoundTripper = %T, want HTTP/2 %v", transport.RoundTripper, tt.wantH2)
+			}
+			// upgrade requests are always sent over HTTP/1.1
+			if transport.Upgrade == nil || transport.Upgrade.ForceAttemptHTTP2 {
+				t.Error("upgrade transport doesn't speak HTTP/1.1")
+			}
+		})
+	}
+}
+
+func TestNewTransportDefaults(t *testing.T) {
+	transport, err := NewTransport(ProtocolHTTP1, &TransportConfig{MaxIdleConnsPerHost: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transport.Upgrade.MaxIdleConnsPerHost != 64 {
+		t.Errorf("MaxIdleConnsPerHost = %d, want 64", transport.Upgrade.MaxIdleConnsPerHost)
+	}
+	if transport.Upgrade.MaxIdleConns != DefaultMaxIdleConns || transport.Upgrade.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("MaxIdleConns = %d, IdleConnTimeout = %s, want the defaults", transport.Upgrade.MaxIdleConns, transport.Upgrade.IdleConnTimeout)
+	}
+}
+
+func TestTransportCAFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+	}{
+		{name: "https", protocol: ProtocolHTTPS},
+		{name: "h2", protocol: ProtocolH2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				_, _ = rw.Write([]byte(req.Proto))
+			}))
+			server.EnableHTTP2 = true
+			server.StartTLS()
+			defer server.Close()
+
+			caFile := filepath.Join(t.TempDir(), "ca.pem")
+			data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+			if err := os.WriteFile(caFile, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			transport, err := NewTransport(tt.protocol, &TransportConfig{TLS: TransportTLSConfig{CAFile: caFile}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewBackend("backend", server.URL, tt.protocol, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			b.Serve(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			wantProto := "HTTP/1.1"
+			if tt.protocol == ProtocolH2 {
+				wantProto = "HTTP/2.0"
+			}
+			if rec.Body.String() != wantProto {
+				t.Errorf("backend protocol = %s, want %s", rec.Body.String(), wantProto)
+			}
+		})
+	}
+}
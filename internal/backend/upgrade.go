@@ -0,0 +1,366 @@
+package backend
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultIdleTimeout is the time after which an upgraded connection without
+	// traffic in either direction is closed.
+	DefaultIdleTimeout = 5 * time.Minute
+
+	// CloseGoingAway is the WebSocket close code sent when a connection is idle
+	// or the other side went away without closing it.
+	CloseGoingAway = 1001
+	// CloseServiceRestart is the WebSocket close code telling clients to reconnect,
+	// sent when the backend of a connection is removed or rebalanced.
+	CloseServiceRestart = 1012
+
+	// closeWriteTimeout bounds the write of a close frame to a peer which
+	// doesn't read, such as a client whose TCP window is full.
+	closeWriteTimeout = time.Second
+
+	websocketProtocol = "websocket"
+	websocketOpClose  = 0x8
+	websocketFinBit   = 0x80
+	websocketMaskBit  = 0x80
+)
+
+var (
+	ErrUpgradeNotSupported = errors.New("http.ResponseWriter does not support hijacking")
+)
+
+// WithIdleTimeout returns a context setting the idle timeout of the upgraded
+// connections of the requests.
+func WithIdleTimeout(ctx context.Context, idleTimeout time.Duration) context.Context {
+	return context.WithValue(ctx, idleTimeoutKey{}, idleTimeout)
+}
+
+// IdleTimeout returns the idle timeout of upgraded connections set in the
+// context, zero for the default.
+func IdleTimeout(ctx context.Context) time.Duration {
+	idleTimeout, _ := ctx.Value(idleTimeoutKey{}).(time.Duration)
+	return idleTimeout
+}
+
+type idleTimeoutKey struct{}
+
+// IsUpgrade returns whether the request asks to switch protocols, such as to WebSocket.
+func IsUpgrade(req *http.Request) bool {
+	return req.ProtoMajor == 1 && req.Header.Get("Upgrade") != "" && hasToken(req.Header.Get("Connection"), "upgrade")
+}
+
+// UpgradedConn is a connection switched to another protocol, proxied between
+// the client and the backend.
+type UpgradedConn struct {
+	Protocol string
+
+	client  net.Conn
+	backend io.ReadWriteCloser
+
+	// the writes to each side and the frame boundaries of the written bytes
+	clientWriter  *frameWriter
+	backendWriter *frameWriter
+
+	closeOnce sync.Once
+}
+
+// Close closes the connection. WebSocket connections are first sent a close frame
+// with the code to both sides, so that clients can tell a restart from a failure.
+func (c *UpgradedConn) Close(code int) {
+	c.closeOnce.Do(func() {
+		if strings.EqualFold(c.Protocol, websocketProtocol) {
+			c.clientWriter.writeClose(code, false)
+			c.backendWriter.writeClose(code, true)
+		}
+
+		_ = c.client.Close()
+		_ = c.backend.Close()
+	})
+}
+
+// ServeUpgrade proxies the upgrade request to the backend, and the upgraded
+// connection once the backend switches protocols. onUpgrade is called with the
+// upgraded connection before proxying it, if not nil. The connection is closed
+// after the idle timeout without traffic.
+func (b *Backend) ServeUpgrade(rw http.ResponseWriter, req *http.Request, idleTimeout time.Duration, onUpgrade func(conn *UpgradedConn)) {
+	if attempt := attemptOf(req.Context()); attempt != nil {
+		attempt.Backend = b
+	}
+	gotConn, done, ok := b.admit(rw, req)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+
+	outReq := req.Clone(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			gotConn()
+		},
+	}))
+	b.proxy.Director(outReq)
+	outReq.RequestURI = ""
+	for _, header := range []string{"Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding"} {
+		outReq.Header.Del(header)
+	}
+	outReq.Header.Set("Connection", "Upgrade")
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := outReq.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		outReq.Header.Set("X-Forwarded-For", clientIP)
+	}
+
+	start := time.Now()
+	res, err := b.upgradeTransport.RoundTrip(outReq)
+	if err != nil {
+		b.latency.Observe(errorLatencyPenalty)
+		b.observe(req, Result{Err: err})
+		log.Printf("[Backend] Error upgrading connection to %s: %s", b.ID, err)
+		http.Error(rw, "Error occurred while processing request", http.StatusBadGateway)
+		return
+	}
+	b.latency.Observe(time.Since(start))
+	b.observe(req, Result{Status: res.StatusCode})
+
+	// the backend refused to switch protocols, relay its response
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		defer func() {
+			_ = res.Body.Close()
+		}()
+		copyHeader(rw.Header(), res.Header)
+		rw.WriteHeader(res.StatusCode)
+		_, _ = io.Copy(rw, res.Body)
+		return
+	}
+
+	backendConn, ok := res.Body.(io.ReadWriteCloser)
+	if !ok {
+		_ = res.Body.Close()
+		http.Error(rw, "Error occurred while processing request", http.StatusBadGateway)
+		return
+	}
+
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		_ = backendConn.Close()
+		http.Error(rw, ErrUpgradeNotSupported.Error(), http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hj.Hijack()
+	if err != nil {
+		_ = backendConn.Close()
+		log.Printf("[Backend] Error hijacking connection: %s", err)
+		return
+	}
+
+	res.Body = nil
+	if err = res.Write(clientConn); err != nil {
+		_ = clientConn.Close()
+		_ = backendConn.Close()
+		return
+	}
+
+	conn := &UpgradedConn{
+		Protocol: res.Header.Get("Upgrade"),
+
+		client:  clientConn,
+		backend: backendConn,
+
+		clientWriter:  &frameWriter{w: clientConn},
+		backendWriter: &frameWriter{w: backendConn},
+	}
+	if onUpgrade != nil {
+		onUpgrade(conn)
+	}
+
+	idle := time.AfterFunc(idleTimeout, func() {
+		conn.Close(CloseGoingAway)
+	})
+	defer idle.Stop()
+
+	errCh := make(chan error, 2)
+	go func() {
+		// the client may have sent bytes buffered by the server before the hijack
+		errCh <- pipe(conn.backendWriter, io.MultiReader(clientBuf.Reader, clientConn), idle, idleTimeout)
+	}()
+	go func() {
+		errCh <- pipe(conn.clientWriter, backendConn, idle, idleTimeout)
+	}()
+
+	// close both sides once either side is done
+	<-errCh
+	conn.Close(CloseGoingAway)
+	<-errCh
+}
+
+// pipe copies the reader to the writer, resetting the idle timer on traffic.
+func pipe(w *frameWriter, r io.Reader, idle *time.Timer, idleTimeout time.Duration) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			idle.Reset(idleTimeout)
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// frameWriter writes to one side of an upgraded connection, and tracks the
+// WebSocket frames of the written bytes so that a close frame is only written
+// between frames.
+type frameWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+
+	header    []byte
+	remaining uint64
+	closed    bool
+	// sentClose is true once a close frame has been written, ending the WebSocket.
+	sentClose bool
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return 0, net.ErrClosed
+	}
+
+	n, err := w.w.Write(p)
+	w.track(p[:n])
+	return n, err
+}
+
+// track advances the frame state over the written bytes.
+func (w *frameWriter) track(p []byte) {
+	for len(p) > 0 {
+		if w.remaining > 0 {
+			n := uint64(len(p))
+			if n > w.remaining {
+				n = w.remaining
+			}
+			w.remaining -= n
+			p = p[n:]
+			continue
+		}
+
+		w.header = append(w.header, p[0])
+		p = p[1:]
+		if size, ok := frameHeaderSize(w.header); ok && len(w.header) == size {
+			w.remaining = framePayloadLength(w.header)
+			w.sentClose = w.sentClose || w.header[0]&0x0f == websocketOpClose
+			w.header = w.header[:0]
+		}
+	}
+}
+
+// writeClose writes a close frame with the code if the written bytes end at
+// a frame boundary, and stops further writes. Frames sent to backends are masked.
+func (w *frameWriter) writeClose(code int, masked bool) {
+	// don't wait for a write blocked on an unresponsive peer
+	if !w.mutex.TryLock() {
+		return
+	}
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.closed = true
+	if w.sentClose || w.remaining > 0 || len(w.header) > 0 {
+		return
+	}
+
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+
+	frame := []byte{websocketFinBit | websocketOpClose, byte(len(payload))}
+	if masked {
+		// a zero mask leaves the payload unchanged
+		frame[1] |= websocketMaskBit
+		frame = append(frame, 0, 0, 0, 0)
+	}
+	frame = append(frame, payload...)
+
+	if conn, ok := w.w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		_ = conn.SetWriteDeadline(time.Now().Add(closeWriteTimeout))
+	} else if closer, ok := w.w.(io.Closer); ok {
+		// the connection is closed right after the close frame anyway
+		timer := time.AfterFunc(closeWriteTimeout, func() {
+			_ = closer.Close()
+		})
+		defer timer.Stop()
+	}
+	_, _ = w.w.Write(frame)
+}
+
+// frameHeaderSize returns the size of the WebSocket frame header starting with
+// the given bytes, once enough bytes are known.
+func frameHeaderSize(header []byte) (int, bool) {
+	if len(header) < 2 {
+		return 0, false
+	}
+
+	size := 2
+	switch header[1] &^ websocketMaskBit {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if header[1]&websocketMaskBit != 0 {
+		size += 4
+	}
+
+	return size, true
+}
+
+func framePayloadLength(header []byte) uint64 {
+	switch length := header[1] &^ websocketMaskBit; length {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(header[2:10])
+	default:
+		return uint64(length)
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+func hasToken(header, token string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,121 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// frame returns a WebSocket frame of the opcode with the payload, masked with
+// a zero mask if masked.
+func frame(opcode byte, payload []byte, masked bool) []byte {
+	f := []byte{websocketFinBit | opcode}
+
+	var maskBit byte
+	if masked {
+		maskBit = websocketMaskBit
+	}
+	switch {
+	case len(payload) < 126:
+		f = append(f, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		f = append(f, maskBit|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		f = append(f, maskBit|127)
+		for i := 7; i >= 0; i-- {
+			f = append(f, byte(uint64(len(payload))>>(8*i)))
+		}
+	}
+	if masked {
+		f = append(f, 0, 0, 0, 0)
+	}
+
+	return append(f, payload...)
+}
+
+func TestFrameWriterWriteClose(t *testing.T) {
+	closeFrame := []byte{websocketFinBit | websocketOpClose, 2, 0x03, 0xf4}
+	maskedCloseFrame := []byte{websocketFinBit | websocketOpClose, websocketMaskBit | 2, 0, 0, 0, 0, 0x03, 0xf4}
+	text := frame(0x1, []byte("hello"), false)
+	long := frame(0x2, bytes.Repeat([]byte("a"), 300), false)
+	huge := frame(0x2, bytes.Repeat([]byte("a"), 70000), false)
+
+	tests := []struct {
+		name   string
+		writes [][]byte
+		masked bool
+		want   []byte
+	}{
+		{name: "no frames", want: closeFrame},
+		{name: "whole frame", writes: [][]byte{text}, want: closeFrame},
+		{name: "masked", writes: [][]byte{frame(0x1, []byte("hello"), true)}, masked: true, want: maskedCloseFrame},
+		{name: "split header", writes: [][]byte{text[:1], text[1:]}, want: closeFrame},
+		{name: "split payload", writes: [][]byte{text[:4], text[4:]}, want: closeFrame},
+		{name: "frames in one write", writes: [][]byte{append(append([]byte{}, text...), text...)}, want: closeFrame},
+		{name: "16-bit length", writes: [][]byte{long}, want: closeFrame},
+		{name: "64-bit length", writes: [][]byte{huge}, want: closeFrame},
+		{name: "16-bit length split in header", writes: [][]byte{long[:3], long[3:]}, want: closeFrame},
+		{name: "partial header", writes: [][]byte{text[:1]}},
+		{name: "partial extended header", writes: [][]byte{long[:3]}},
+		{name: "partial payload", writes: [][]byte{text[:4]}},
+		{name: "close already sent", writes: [][]byte{frame(websocketOpClose, []byte{0x03, 0xe8}, false)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &frameWriter{w: &buf}
+			for _, p := range tt.writes {
+				if _, err := w.Write(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			written := buf.Len()
+
+			w.writeClose(CloseServiceRestart, tt.masked)
+			if got := buf.Bytes()[written:]; !bytes.Equal(got, tt.want) {
+				t.Errorf("close frame = %x, want %x", got, tt.want)
+			}
+
+			if _, err := w.Write(text); !errors.Is(err, net.ErrClosed) {
+				t.Errorf("Write() after close error = %v, want %v", err, net.ErrClosed)
+			}
+		})
+	}
+}
+
+func TestFrameWriterWriteCloseOnce(t *testing.T) {
+	var buf bytes.Buffer
+	w := &frameWriter{w: &buf}
+
+	w.writeClose(CloseServiceRestart, false)
+	written := buf.Len()
+	w.writeClose(CloseGoingAway, false)
+	if buf.Len() != written {
+		t.Errorf("second close wrote %d bytes", buf.Len()-written)
+	}
+}
+
+func TestFrameWriterWriteCloseTimeout(t *testing.T) {
+	// a peer which doesn't read
+	client, peer := net.Pipe()
+	defer func() {
+		_ = client.Close()
+		_ = peer.Close()
+	}()
+
+	w := &frameWriter{w: client}
+	done := make(chan struct{})
+	go func() {
+		w.writeClose(CloseServiceRestart, false)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(closeWriteTimeout + time.Second):
+		t.Fatal("writeClose blocked on a peer which doesn't read")
+	}
+}
@@ -0,0 +1,211 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// defaultExpiryWarning is how long before expiry a certificate which
+	// hasn't been renewed is reported.
+	defaultExpiryWarning = 14 * 24 * time.Hour
+
+	// acmeCheckInterval is the interval of checking the ACME certificates.
+	acmeCheckInterval = time.Hour
+)
+
+var (
+	ErrNoACMECache = errors.New("ACME requires a cache directory or a cache secret")
+	ErrInvalidCA   = errors.New("no certificate found in CA file")
+)
+
+var (
+	acmeExpiry   = expvar.NewMap("l7_acme_certificate_expiry_seconds")
+	acmeFailures = expvar.NewMap("l7_acme_failures")
+	// acmeExpiring is 1 for the hosts whose certificate is within the expiry
+	// warning, and 0 otherwise.
+	acmeExpiring = expvar.NewMap("l7_acme_certificate_expiring")
+)
+
+// ACMEConfig is the configuration of the certificates obtained from an ACME CA.
+type ACMEConfig struct {
+	// Hosts are the host names for which certificates are obtained.
+	Hosts []string `mapstructure:"hosts"`
+	Email string   `mapstructure:"email"`
+	// DirectoryURL is the directory of the ACME CA, Let's Encrypt if empty.
+	DirectoryURL string `mapstructure:"directory-url"`
+	// CAFile is a PEM file of the CAs trusted when connecting to the ACME
+	// server, such as the root of a local Pebble server.
+	CAFile string `mapstructure:"ca-file"`
+
+	// CacheDir is the directory storing the account key and certificates.
+	CacheDir string `mapstructure:"cache-dir"`
+	// CacheSecret is the Kubernetes secret in the form namespace/name storing
+	// the account key and certificates, shared by every replica.
+	CacheSecret string `mapstructure:"cache-secret"`
+
+	// RenewBefore is how long before expiry certificates are renewed, 30 days if zero.
+	RenewBefore time.Duration `mapstructure:"renew-before"`
+	// ExpiryWarning is how long before expiry a certificate which hasn't been
+	// renewed is reported, 14 days if zero.
+	ExpiryWarning time.Duration `mapstructure:"expiry-warning"`
+}
+
+// ACMEManager obtains and renews the certificates of the configured hosts,
+// solving HTTP-01 challenges with HTTPHandler and TLS-ALPN-01 challenges
+// with GetCertificate.
+type ACMEManager struct {
+	manager *autocert.Manager
+	hosts   map[string]bool
+
+	expiryWarning time.Duration
+}
+
+func NewACMEManager(config *ACMEConfig) (*ACMEManager, error) {
+	var cache autocert.Cache
+	switch {
+	case config.CacheSecret != "":
+		secretCache, err := NewSecretCache(config.CacheSecret)
+		if err != nil {
+			return nil, err
+		}
+		cache = secretCache
+	case config.CacheDir != "":
+		cache = autocert.DirCache(config.CacheDir)
+	default:
+		return nil, ErrNoACMECache
+	}
+
+	client := &acme.Client{
+		DirectoryURL: config.DirectoryURL,
+	}
+	if config.CAFile != "" {
+		httpClient, err := newCAClient(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		client.HTTPClient = httpClient
+	}
+
+	hosts := make(map[string]bool)
+	for _, host := range config.Hosts {
+		hosts[strings.ToLower(host)] = true
+	}
+
+	expiryWarning := config.ExpiryWarning
+	if expiryWarning == 0 {
+		expiryWarning = defaultExpiryWarning
+	}
+
+	return &ACMEManager{
+		manager: &autocert.Manager{
+			Prompt:      autocert.AcceptTOS,
+			Cache:       cache,
+			HostPolicy:  autocert.HostWhitelist(config.Hosts...),
+			RenewBefore: config.RenewBefore,
+			Client:      client,
+			Email:       config.Email,
+		},
+		hosts:         hosts,
+		expiryWarning: expiryWarning,
+	}, nil
+}
+
+// Handles returns whether the certificate of the handshake is obtained by ACME.
+func (m *ACMEManager) Handles(hello *tls.ClientHelloInfo) bool {
+	return m.hosts[strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))]
+}
+
+// GetCertificate returns the certificate of the handshake, obtaining it on the
+// first handshake of a host.
+func (m *ACMEManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.manager.GetCertificate(hello)
+}
+
+// HTTPHandler answers HTTP-01 challenges, and passes other requests to the fallback.
+func (m *ACMEManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.manager.HTTPHandler(fallback)
+}
+
+// Monitor obtains the certificates of all hosts in the background, then checks
+// them periodically. Renewal is done by the manager ahead of expiry, so a
+// certificate close to expiry means its renewal keeps failing.
+func (m *ACMEManager) Monitor() {
+	go func() {
+		m.check()
+
+		t := time.NewTicker(acmeCheckInterval)
+		for range t.C {
+			m.check()
+		}
+	}()
+}
+
+func (m *ACMEManager) check() {
+	for host := range m.hosts {
+		// prefer the ECDSA certificate served to modern clients
+		certificate, err := m.manager.GetCertificate(&tls.ClientHelloInfo{
+			ServerName:       host,
+			SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedCurves:  []tls.CurveID{tls.CurveP256},
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		})
+		if err != nil {
+			acmeFailures.Add(host, 1)
+			log.Printf("[Certificate] Error obtaining ACME certificate of %s: %v", host, err)
+			continue
+		}
+
+		notAfter := certificate.Leaf.NotAfter
+		acmeExpiry.Set(host, expvarInt(notAfter.Unix()))
+
+		remaining := time.Until(notAfter)
+		expiring := remaining < m.expiryWarning
+		acmeExpiring.Set(host, expvarInt(boolInt(expiring)))
+		if expiring {
+			log.Printf("[Certificate] ACME certificate of %s expires in %s, renewal is failing", host, remaining.Round(time.Minute))
+		}
+	}
+}
+
+// newCAClient returns an HTTP client trusting the CAs of the PEM file.
+func newCAClient(caFile string) (*http.Client, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCA, caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func expvarInt(value int64) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(value)
+	return v
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package certificate
+
+import (
+	"context"
+	"encoding/base64"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// SecretCache is an autocert.Cache storing the ACME account key and every
+// certificate in one Kubernetes secret, so that all replicas share them.
+type SecretCache struct {
+	client    clientset.Interface
+	namespace string
+	name      string
+}
+
+// NewSecretCache creates a cache in the secret named "namespace/name". The
+// secret is created on the first write if it doesn't exist.
+func NewSecretCache(secret string) (*SecretCache, error) {
+	namespace, name, err := splitSecretName(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretCache{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}, nil
+}
+
+func (c *SecretCache) Get(ctx context.Context, key string) ([]byte, error) {
+	secret, err := c.client.CoreV1().Secrets(c.namespace).Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok := secret.Data[secretDataKey(key)]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+
+	return data, nil
+}
+
+// Put stores the data of the key. Concurrent writes of replicas conflict on
+// the resource version of the secret, and are retried on the latest secret.
+func (c *SecretCache) Put(ctx context.Context, key string, data []byte) error {
+	return retry.OnError(retry.DefaultRetry, isConflict, func() error {
+		secrets := c.client.CoreV1().Secrets(c.namespace)
+		secret, err := secrets.Get(ctx, c.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = secrets.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      c.name,
+					Namespace: c.namespace,
+				},
+				Data: map[string][]byte{secretDataKey(key): data},
+			}, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[secretDataKey(key)] = data
+
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *SecretCache) Delete(ctx context.Context, key string) error {
+	return retry.OnError(retry.DefaultRetry, isConflict, func() error {
+		secrets := c.client.CoreV1().Secrets(c.namespace)
+		secret, err := secrets.Get(ctx, c.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, ok := secret.Data[secretDataKey(key)]; !ok {
+			return nil
+		}
+		delete(secret.Data, secretDataKey(key))
+
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// isConflict returns whether a write lost the race with another replica,
+// either updating the secret or creating it first.
+func isConflict(err error) bool {
+	return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+}
+
+// secretDataKey encodes the cache key, which may contain characters such as
+// "+" that are not allowed in the keys of secret data.
+func secretDataKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
@@ -0,0 +1,101 @@
+package certificate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestSecretCachePut(t *testing.T) {
+	secrets := schema.GroupResource{Resource: "secrets"}
+
+	tests := []struct {
+		name string
+		// existing is whether the secret exists before the write
+		existing bool
+		// verb fails with err the first time
+		verb    string
+		err     error
+		wantErr bool
+	}{
+		{name: "create"},
+		{name: "update", existing: true},
+		{name: "update conflict", existing: true, verb: "update", err: apierrors.NewConflict(secrets, "l7-acme", errors.New("modified"))},
+		{name: "create race", verb: "create", err: apierrors.NewAlreadyExists(secrets, "l7-acme")},
+		{name: "forbidden", existing: true, verb: "update", err: apierrors.NewForbidden(secrets, "l7-acme", errors.New("denied")), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			if tt.existing {
+				_, err := client.CoreV1().Secrets("yorkie").Create(context.Background(), &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "l7-acme", Namespace: "yorkie"},
+					Data:       map[string][]byte{secretDataKey("acme_account+key"): []byte("account")},
+				}, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.verb != "" {
+				failed := false
+				client.PrependReactor(tt.verb, "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+					if failed && !tt.wantErr {
+						return false, nil, nil
+					}
+					failed = true
+					return true, nil, tt.err
+				})
+			}
+
+			cache := &SecretCache{client: client, namespace: "yorkie", name: "l7-acme"}
+			err := cache.Put(context.Background(), "api.yorkie.dev", []byte("certificate"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Put() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			data, err := cache.Get(context.Background(), "api.yorkie.dev")
+			if err != nil || string(data) != "certificate" {
+				t.Errorf("Get() = %q, %v, want the stored certificate", data, err)
+			}
+			if tt.existing {
+				// the other keys of the secret are kept
+				if data, err := cache.Get(context.Background(), "acme_account+key"); err != nil || string(data) != "account" {
+					t.Errorf("Get() of another key = %q, %v, want it kept", data, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSecretCacheDelete(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	cache := &SecretCache{client: client, namespace: "yorkie", name: "l7-acme"}
+	ctx := context.Background()
+
+	if err := cache.Delete(ctx, "api.yorkie.dev"); err != nil {
+		t.Fatalf("Delete() without the secret error = %v", err)
+	}
+	if err := cache.Put(ctx, "api.yorkie.dev", []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Delete(ctx, "api.yorkie.dev"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "api.yorkie.dev"); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, autocert.ErrCacheMiss)
+	}
+}
@@ -0,0 +1,145 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// fileWatchInterval is the interval of checking certificate files for changes.
+	fileWatchInterval = 10 * time.Second
+)
+
+var (
+	ErrNoCertificateConfigured = errors.New("no certificate configured")
+	ErrInvalidMinVersion       = errors.New("invalid minimum TLS version")
+	ErrUnknownCipherSuite      = errors.New("unknown cipher suite")
+)
+
+// Config is the configuration of the TLS listener.
+type Config struct {
+	Addr string `mapstructure:"addr"`
+	// MinVersion is the minimum TLS version, one of 1.0, 1.1, 1.2 and 1.3. It is 1.2 if empty.
+	MinVersion string `mapstructure:"min-version"`
+	// CipherSuites are the names of the enabled TLS 1.0-1.2 cipher suites, such as
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Go's secure defaults are used if empty.
+	CipherSuites []string `mapstructure:"cipher-suites"`
+
+	Certificates []CertificateConfig `mapstructure:"certificates"`
+	// ACME obtains the certificates of its hosts from an ACME CA. Other host
+	// names are served with the configured certificates.
+	ACME ACMEConfig `mapstructure:"acme"`
+}
+
+// CertificateConfig is the source of a certificate, either PEM files or a Kubernetes TLS secret.
+type CertificateConfig struct {
+	CertFile string `mapstructure:"cert-file"`
+	KeyFile  string `mapstructure:"key-file"`
+	// Secret is the Kubernetes TLS secret in the form namespace/name.
+	Secret string `mapstructure:"secret"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig loads the configured certificates, starts watching them for
+// changes, and returns the TLS configuration of the listener. The ACME manager
+// is nil unless ACME hosts are configured.
+func NewTLSConfig(config *Config) (*tls.Config, *ACMEManager, error) {
+	if len(config.Certificates) == 0 && len(config.ACME.Hosts) == 0 {
+		return nil, nil, ErrNoCertificateConfigured
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if config.MinVersion != "" {
+		version, ok := tlsVersions[config.MinVersion]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidMinVersion, config.MinVersion)
+		}
+		minVersion = version
+	}
+
+	cipherSuites, err := parseCipherSuites(config.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store := NewStore(len(config.Certificates))
+	for i, certificateConfig := range config.Certificates {
+		if certificateConfig.Secret != "" {
+			source, err := NewSecretSource(certificateConfig.Secret, store, i)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err = source.Load(); err != nil {
+				return nil, nil, fmt.Errorf("secret %s: %w", certificateConfig.Secret, err)
+			}
+			source.Watch()
+			continue
+		}
+
+		source := NewFileSource(certificateConfig.CertFile, certificateConfig.KeyFile, store, i)
+		if err = source.Load(); err != nil {
+			return nil, nil, fmt.Errorf("certificate %s: %w", certificateConfig.CertFile, err)
+		}
+		source.Watch(fileWatchInterval)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: store.GetCertificate,
+	}
+	if len(config.ACME.Hosts) == 0 {
+		return tlsConfig, nil, nil
+	}
+
+	acmeManager, err := NewACMEManager(&config.ACME)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if acmeManager.Handles(hello) {
+			return acmeManager.GetCertificate(hello)
+		}
+		return store.GetCertificate(hello)
+	}
+	// accept TLS-ALPN-01 challenges
+	tlsConfig.NextProtos = []string{acme.ALPNProto}
+
+	return tlsConfig, acmeManager, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+
+	var cipherSuites []uint16
+	for _, name := range names {
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownCipherSuite, name)
+		}
+		cipherSuites = append(cipherSuites, id)
+	}
+
+	return cipherSuites, nil
+}
@@ -0,0 +1,91 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"time"
+)
+
+// FileSource loads a certificate from PEM files, and reloads it when the files change.
+type FileSource struct {
+	certFile string
+	keyFile  string
+
+	store *Store
+	index int
+
+	modTime time.Time
+}
+
+func NewFileSource(certFile, keyFile string, store *Store, index int) *FileSource {
+	return &FileSource{
+		certFile: certFile,
+		keyFile:  keyFile,
+
+		store: store,
+		index: index,
+	}
+}
+
+// Load loads the certificate into the store.
+func (s *FileSource) Load() error {
+	modTime, err := s.lastModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if err = s.store.Set(s.index, &certificate); err != nil {
+		return err
+	}
+	s.modTime = modTime
+
+	return nil
+}
+
+// Watch reloads the certificate whenever the files are modified.
+func (s *FileSource) Watch(interval time.Duration) {
+	go s.watch(interval)
+}
+
+func (s *FileSource) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	for range t.C {
+		modTime, err := s.lastModTime()
+		if err != nil {
+			log.Printf("[Certificate] Error checking %s: %v", s.certFile, err)
+			continue
+		}
+		if !modTime.After(s.modTime) {
+			continue
+		}
+
+		// keep serving the previous certificate if the new one is invalid, for
+		// example when only one of the files has been written yet
+		if err = s.Load(); err != nil {
+			log.Printf("[Certificate] Error reloading %s: %v", s.certFile, err)
+			continue
+		}
+		log.Printf("[Certificate] Reloaded certificate %s", s.certFile)
+	}
+}
+
+func (s *FileSource) lastModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{s.certFile, s.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
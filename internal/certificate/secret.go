@@ -0,0 +1,136 @@
+package certificate
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// rewatchDelay is the delay before watching the secret again after the watch ends.
+	rewatchDelay = 5 * time.Second
+)
+
+var (
+	ErrInvalidSecretName = errors.New("secret must be in the form namespace/name")
+)
+
+// SecretSource loads a certificate from a Kubernetes TLS secret, and reloads it
+// when the secret is updated, for example by cert-manager.
+type SecretSource struct {
+	client    *clientset.Clientset
+	namespace string
+	name      string
+
+	store *Store
+	index int
+}
+
+// NewSecretSource creates a source of the secret named "namespace/name".
+func NewSecretSource(secret string, store *Store, index int) (*SecretSource, error) {
+	namespace, name, err := splitSecretName(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretSource{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+
+		store: store,
+		index: index,
+	}, nil
+}
+
+// Load loads the certificate into the store.
+func (s *SecretSource) Load() error {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(context.Background(), s.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	return s.set(secret)
+}
+
+// Watch reloads the certificate whenever the secret is updated.
+func (s *SecretSource) Watch() {
+	go s.watch()
+}
+
+func (s *SecretSource) watch() {
+	for {
+		watcher, err := s.client.CoreV1().Secrets(s.namespace).Watch(context.Background(), metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", s.name).String(),
+		})
+		if err != nil {
+			log.Printf("[Certificate] Error watching secret %s/%s: %v", s.namespace, s.name, err)
+			time.Sleep(rewatchDelay)
+			continue
+		}
+
+		for event := range watcher.ResultChan() {
+			if event.Type != watch.Added && event.Type != watch.Modified {
+				continue
+			}
+
+			secret, ok := event.Object.(*corev1.Secret)
+			if !ok {
+				continue
+			}
+
+			if err = s.set(secret); err != nil {
+				log.Printf("[Certificate] Error reloading secret %s/%s: %v", s.namespace, s.name, err)
+				continue
+			}
+			log.Printf("[Certificate] Reloaded certificate of secret %s/%s", s.namespace, s.name)
+		}
+
+		// the API server closes watches periodically
+		time.Sleep(rewatchDelay)
+	}
+}
+
+func (s *SecretSource) set(secret *corev1.Secret) error {
+	certificate, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return err
+	}
+
+	return s.store.Set(s.index, &certificate)
+}
+
+// splitSecretName splits a secret name in the form namespace/name.
+func splitSecretName(secret string) (string, string, error) {
+	namespace, name, ok := strings.Cut(secret, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidSecretName, secret)
+	}
+
+	return namespace, name, nil
+}
+
+func newKubernetesClient() (*clientset.Clientset, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return clientset.NewForConfig(config)
+}
@@ -0,0 +1,94 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoCertificate = errors.New("no certificate available")
+)
+
+// Store holds the certificates of the TLS listener, and selects the certificate
+// of each handshake by its server name (SNI). Certificates can be replaced at
+// any time; new handshakes use the new certificate while established
+// connections keep the one they were made with.
+type Store struct {
+	mutex sync.RWMutex
+	// certificates is indexed by the position of their source in the configuration,
+	// so that the first configured certificate is the default one.
+	certificates []*tls.Certificate
+	names        map[string]*tls.Certificate
+}
+
+func NewStore(size int) *Store {
+	return &Store{
+		certificates: make([]*tls.Certificate, size),
+		names:        make(map[string]*tls.Certificate),
+	}
+}
+
+// Set replaces the certificate of the source at the given index.
+func (s *Store) Set(index int, certificate *tls.Certificate) error {
+	if certificate.Leaf == nil {
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return err
+		}
+		certificate.Leaf = leaf
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.certificates[index] = certificate
+
+	// rebuild the names in reverse order, so that earlier certificates win when names overlap
+	names := make(map[string]*tls.Certificate)
+	for i := len(s.certificates) - 1; i >= 0; i-- {
+		c := s.certificates[i]
+		if c == nil {
+			continue
+		}
+
+		if c.Leaf.Subject.CommonName != "" {
+			names[strings.ToLower(c.Leaf.Subject.CommonName)] = c
+		}
+		for _, name := range c.Leaf.DNSNames {
+			names[strings.ToLower(name)] = c
+		}
+	}
+	s.names = names
+
+	return nil
+}
+
+// GetCertificate returns the certificate matching the server name of the
+// handshake, exactly or by wildcard, or the default certificate otherwise.
+// It is meant to be used as tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if c, ok := s.names[name]; ok {
+		return c, nil
+	}
+
+	if _, domain, ok := strings.Cut(name, "."); ok {
+		if c, ok := s.names["*."+domain]; ok {
+			return c, nil
+		}
+	}
+
+	for _, c := range s.certificates {
+		if c != nil {
+			return c, nil
+		}
+	}
+
+	return nil, ErrNoCertificate
+}
@@ -0,0 +1,184 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCertificate returns a self-signed certificate of the common name and DNS names.
+func newCertificate(t *testing.T, commonName string, dnsNames ...string) *tls.Certificate {
+	certPEM, keyPEM := newCertificatePEM(t, commonName, dnsNames...)
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &certificate
+}
+
+func newCertificatePEM(t *testing.T, commonName string, dnsNames ...string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestStoreGetCertificate(t *testing.T) {
+	defaultCertificate := newCertificate(t, "default.local")
+	api := newCertificate(t, "api.yorkie.dev")
+	wildcard := newCertificate(t, "", "*.yorkie.dev", "yorkie.dev")
+	// a later certificate of an already served name
+	shadowed := newCertificate(t, "", "api.yorkie.dev", "whoami.local")
+
+	store := NewStore(4)
+	for i, certificate := range []*tls.Certificate{defaultCertificate, api, wildcard, shadowed} {
+		if err := store.Set(i, certificate); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		serverName string
+		want       *tls.Certificate
+	}{
+		{serverName: "api.yorkie.dev", want: api},
+		{serverName: "API.Yorkie.Dev", want: api},
+		{serverName: "api.yorkie.dev.", want: api},
+		{serverName: "docs.yorkie.dev", want: wildcard},
+		{serverName: "yorkie.dev", want: wildcard},
+		{serverName: "whoami.local", want: shadowed},
+		// wildcards only match one label
+		{serverName: "a.b.yorkie.dev", want: defaultCertificate},
+		{serverName: "unknown.local", want: defaultCertificate},
+		{serverName: "", want: defaultCertificate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			got, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.serverName})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("GetCertificate(%q) = %v, want %v", tt.serverName, got.Leaf.DNSNames, tt.want.Leaf.DNSNames)
+			}
+		})
+	}
+}
+
+func TestStoreSet(t *testing.T) {
+	store := NewStore(2)
+	if _, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "api.yorkie.dev"}); !errors.Is(err, ErrNoCertificate) {
+		t.Fatalf("GetCertificate() of an empty store error = %v, want %v", err, ErrNoCertificate)
+	}
+
+	// the default certificate is the first configured one, even if loaded later
+	second := newCertificate(t, "whoami.local")
+	if err := store.Set(1, second); err != nil {
+		t.Fatal(err)
+	}
+	first := newCertificate(t, "api.yorkie.dev")
+	if err := store.Set(0, first); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.local"}); got != first {
+		t.Error("default certificate is not the first configured one")
+	}
+
+	// replacing a certificate replaces its names
+	rotated := newCertificate(t, "", "docs.yorkie.dev")
+	if err := store.Set(1, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "docs.yorkie.dev"}); got != rotated {
+		t.Error("rotated certificate not served for its name")
+	}
+	if got, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: "whoami.local"}); got != first {
+		t.Error("name of the replaced certificate not served with the default certificate")
+	}
+}
+
+func TestFileSourceLoad(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	write := func(commonName string) {
+		certPEM, keyPEM := newCertificatePEM(t, commonName)
+		if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	served := func(store *Store) string {
+		c, err := store.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Leaf.Subject.CommonName
+	}
+
+	store := NewStore(1)
+	source := NewFileSource(certFile, keyFile, store, 0)
+	if err := source.Load(); err == nil {
+		t.Fatal("Load() of missing files succeeded")
+	}
+
+	write("api.yorkie.dev")
+	if err := source.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := served(store); got != "api.yorkie.dev" {
+		t.Fatalf("served %s, want api.yorkie.dev", got)
+	}
+
+	// a rotated certificate is served once reloaded, and an invalid one keeps
+	// the previous certificate
+	write("rotated.yorkie.dev")
+	if err := source.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := served(store); got != "rotated.yorkie.dev" {
+		t.Errorf("served %s after the rotation, want rotated.yorkie.dev", got)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Load(); err == nil {
+		t.Error("Load() of an invalid key succeeded")
+	}
+	if got := served(store); got != "rotated.yorkie.dev" {
+		t.Errorf("served %s after an invalid rotation, want rotated.yorkie.dev", got)
+	}
+}
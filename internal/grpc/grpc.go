@@ -0,0 +1,154 @@
+package grpc
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code is a gRPC status code.
+type Code int
+
+const (
+	OK                Code = 0
+	Canceled          Code = 1
+	Unknown           Code = 2
+	DeadlineExceeded  Code = 4
+	PermissionDenied  Code = 7
+	ResourceExhausted Code = 8
+	Unimplemented     Code = 12
+	Internal          Code = 13
+	Unavailable       Code = 14
+	Unauthenticated   Code = 16
+)
+
+const (
+	StatusHeader  = "Grpc-Status"
+	MessageHeader = "Grpc-Message"
+	TimeoutHeader = "Grpc-Timeout"
+
+	contentTypePrefix = "application/grpc"
+)
+
+// IsGRPC returns whether the request is a gRPC request, including gRPC-Web.
+func IsGRPC(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), contentTypePrefix)
+}
+
+// IsGRPCResponse returns whether the response is a gRPC response.
+func IsGRPCResponse(res *http.Response) bool {
+	return strings.HasPrefix(res.Header.Get("Content-Type"), contentTypePrefix)
+}
+
+// Error replies to the request with the error message and HTTP status code.
+// gRPC requests get a trailers-only response with the gRPC status matching the
+// HTTP status instead, which gRPC clients can act on, such as by retrying UNAVAILABLE.
+func Error(rw http.ResponseWriter, req *http.Request, message string, code int) {
+	if !IsGRPC(req) {
+		http.Error(rw, message, code)
+		return
+	}
+
+	WriteStatus(rw, req, CodeFromHTTP(code), message)
+}
+
+// WriteStatus replies to the gRPC request with a trailers-only response of the status.
+func WriteStatus(rw http.ResponseWriter, req *http.Request, code Code, message string) {
+	header := rw.Header()
+	header.Set("Content-Type", responseContentType(req))
+	header.Set(StatusHeader, strconv.Itoa(int(code)))
+	if message != "" {
+		header.Set(MessageHeader, EncodeMessage(message))
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// CodeFromHTTP maps an HTTP status to a gRPC status, as gRPC clients do for
+// responses without gRPC status.
+func CodeFromHTTP(status int) Code {
+	switch status {
+	case http.StatusOK:
+		return OK
+	case http.StatusBadRequest:
+		return Internal
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound:
+		return Unimplemented
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return DeadlineExceeded
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return Unavailable
+	}
+
+	return Unknown
+}
+
+// EncodeMessage percent-encodes the status message as the gRPC spec requires.
+func EncodeMessage(message string) string {
+	var builder strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&builder, "%%%02X", c)
+			continue
+		}
+		builder.WriteByte(c)
+	}
+
+	return builder.String()
+}
+
+// ParseTimeout parses the value of the grpc-timeout header, such as "100m" for
+// 100 milliseconds.
+func ParseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout: %q", value)
+	}
+
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout: %q", value)
+	}
+
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, fmt.Errorf("invalid grpc-timeout unit: %q", value)
+	}
+
+	// hours of eight digits overflow a duration, which is as
+	// good as no deadline
+	if amount > math.MaxInt64/int64(unit) {
+		return math.MaxInt64, nil
+	}
+	return time.Duration(amount) * unit, nil
+}
+
+// responseContentType returns the content type of the response to the request,
+// which keeps the gRPC-Web or codec suffix of the request.
+func responseContentType(req *http.Request) string {
+	contentType := req.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, contentTypePrefix) {
+		return contentType
+	}
+
+	return contentTypePrefix
+}
@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "1H", want: time.Hour},
+		{value: "2M", want: 2 * time.Minute},
+		{value: "30S", want: 30 * time.Second},
+		{value: "100m", want: 100 * time.Millisecond},
+		{value: "250u", want: 250 * time.Microsecond},
+		{value: "999n", want: 999 * time.Nanosecond},
+		{value: "0S", want: 0},
+		{value: "99999999S", want: 99999999 * time.Second},
+		{value: "99999999M", want: 99999999 * time.Minute},
+		{value: "99999999H", want: math.MaxInt64},
+		{value: "", wantErr: true},
+		{value: "S", wantErr: true},
+		{value: "100", wantErr: true},
+		{value: "100s", wantErr: true},
+		{value: "-1S", wantErr: true},
+		{value: "1.5S", wantErr: true},
+		{value: "100000000S", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTimeout(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeout(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTimeout(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{message: "backend unavailable", want: "backend unavailable"},
+		{message: "100% done", want: "100%25 done"},
+		{message: "line\nbreak", want: "line%0Abreak"},
+		{message: "café", want: "caf%C3%A9"},
+	}
+
+	for _, tt := range tests {
+		if got := EncodeMessage(tt.message); got != tt.want {
+			t.Errorf("EncodeMessage(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package hashkey
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FallbackRandom sends keyless requests to a random backend.
+	FallbackRandom = "random"
+	// FallbackReject rejects keyless requests with 400 Bad Request.
+	FallbackReject = "reject"
+	// FallbackRemoteAddr uses the remote address of keyless requests as key,
+	// so that requests of the same connection go to the same backend.
+	FallbackRemoteAddr = "remote-addr"
+)
+
+const (
+	SourceHeader   = "header"
+	SourceCookie   = "cookie"
+	SourceQuery    = "query"
+	SourcePath     = "path"
+	SourceClientIP = "client-ip"
+)
+
+var (
+	ErrNoKey           = errors.New("hash key not found in request")
+	ErrInvalidSource   = errors.New("invalid hash key source")
+	ErrInvalidFallback = errors.New("invalid hash key fallback")
+)
+
+// KeyExtractor extracts the hash key of a request from one source.
+type KeyExtractor interface {
+	ExtractKey(req *http.Request) (string, bool)
+}
+
+// HeaderExtractor extracts the key from a request header.
+type HeaderExtractor struct {
+	Name string
+}
+
+func (e *HeaderExtractor) ExtractKey(req *http.Request) (string, bool) {
+	key := req.Header.Get(e.Name)
+	return key, key != ""
+}
+
+// CookieExtractor extracts the key from a cookie.
+type CookieExtractor struct {
+	Name string
+}
+
+func (e *CookieExtractor) ExtractKey(req *http.Request) (string, bool) {
+	cookie, err := req.Cookie(e.Name)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+
+	return cookie.Value, true
+}
+
+// QueryExtractor extracts the key from a query parameter.
+type QueryExtractor struct {
+	Name string
+}
+
+func (e *QueryExtractor) ExtractKey(req *http.Request) (string, bool) {
+	key := req.URL.Query().Get(e.Name)
+	return key, key != ""
+}
+
+// PathExtractor extracts the key from the first capture group of a regular
+// expression matched against the request path.
+type PathExtractor struct {
+	Pattern *regexp.Regexp
+}
+
+func (e *PathExtractor) ExtractKey(req *http.Request) (string, bool) {
+	matches := e.Pattern.FindStringSubmatch(req.URL.Path)
+	if len(matches) < 2 || matches[1] == "" {
+		return "", false
+	}
+
+	return matches[1], true
+}
+
+// ClientIPExtractor uses the IP address of the client as key.
+type ClientIPExtractor struct{}
+
+func (e *ClientIPExtractor) ExtractKey(req *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr, req.RemoteAddr != ""
+	}
+
+	return host, true
+}
+
+// ParseExtractor parses a source specification such as "header:X-Shard-Key",
+// "cookie:shard", "query:key", "path:^/documents/([^/]+)", "client-ip" or "yorkie-body".
+func ParseExtractor(spec string) (KeyExtractor, error) {
+	source, arg, _ := strings.Cut(spec, ":")
+	switch source {
+	case SourceHeader:
+		if arg == "" {
+			return nil, fmt.Errorf("%w: %s requires a header name", ErrInvalidSource, spec)
+		}
+		return &HeaderExtractor{Name: arg}, nil
+	case SourceCookie:
+		if arg == "" {
+			return nil, fmt.Errorf("%w: %s requires a cookie name", ErrInvalidSource, spec)
+		}
+		return &CookieExtractor{Name: arg}, nil
+	case SourceQuery:
+		if arg == "" {
+			return nil, fmt.Errorf("%w: %s requires a query parameter name", ErrInvalidSource, spec)
+		}
+		return &QueryExtractor{Name: arg}, nil
+	case SourcePath:
+		pattern, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidSource, spec, err)
+		}
+		if pattern.NumSubexp() < 1 {
+			return nil, fmt.Errorf("%w: %s requires a capture group", ErrInvalidSource, spec)
+		}
+		return &PathExtractor{Pattern: pattern}, nil
+	case SourceClientIP:
+		return &ClientIPExtractor{}, nil
+	case SourceYorkieBody:
+		return NewYorkieBodyExtractor(), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrInvalidSource, spec)
+}
+
+// Pipeline extracts the hash key of a request from the first of its
+// extractors which finds one, and applies its fallback policy otherwise.
+type Pipeline struct {
+	extractors []KeyExtractor
+	fallback   string
+}
+
+// NewPipeline creates a pipeline of the given source specifications, tried in order.
+func NewPipeline(sources []string, fallback string) (*Pipeline, error) {
+	var extractors []KeyExtractor
+	for _, source := range sources {
+		extractor, err := ParseExtractor(source)
+		if err != nil {
+			return nil, err
+		}
+		extractors = append(extractors, extractor)
+	}
+
+	return NewPipelineWithExtractors(extractors, fallback)
+}
+
+// NewPipelineWithExtractors creates a pipeline of the given extractors, tried in order.
+func NewPipelineWithExtractors(extractors []KeyExtractor, fallback string) (*Pipeline, error) {
+	switch fallback {
+	case FallbackRandom, FallbackReject, FallbackRemoteAddr:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFallback, fallback)
+	}
+
+	return &Pipeline{
+		extractors: extractors,
+		fallback:   fallback,
+	}, nil
+}
+
+// Extract returns the hash key of the request. It returns ErrNoKey if no
+// extractor finds a key and the fallback policy rejects keyless requests.
+func (p *Pipeline) Extract(req *http.Request) (string, error) {
+	for _, extractor := range p.extractors {
+		if key, ok := extractor.ExtractKey(req); ok {
+			return key, nil
+		}
+	}
+
+	switch p.fallback {
+	case FallbackRemoteAddr:
+		return req.RemoteAddr, nil
+	case FallbackRandom:
+		return strconv.FormatUint(rand.Uint64(), 36), nil
+	}
+
+	return "", ErrNoKey
+}
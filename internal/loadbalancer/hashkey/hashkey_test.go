@@ -0,0 +1,122 @@
+package hashkey
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseExtractor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/documents/doc-1/changes?key=query-key", nil)
+	req.Header.Set("X-Shard-Key", "header-key")
+	req.AddCookie(&http.Cookie{Name: "shard", Value: "cookie-key"})
+	req.RemoteAddr = "10.0.0.1:54321"
+
+	tests := []struct {
+		spec    string
+		want    string
+		wantOK  bool
+		wantErr bool
+	}{
+		{spec: "header:X-Shard-Key", want: "header-key", wantOK: true},
+		{spec: "header:X-Missing"},
+		{spec: "cookie:shard", want: "cookie-key", wantOK: true},
+		{spec: "cookie:missing"},
+		{spec: "query:key", want: "query-key", wantOK: true},
+		{spec: "query:missing"},
+		{spec: "path:^/documents/([^/]+)", want: "doc-1", wantOK: true},
+		{spec: "path:^/users/([^/]+)"},
+		{spec: "client-ip", want: "10.0.0.1", wantOK: true},
+		{spec: "yorkie-body"},
+		{spec: "header", wantErr: true},
+		{spec: "cookie:", wantErr: true},
+		{spec: "query", wantErr: true},
+		{spec: "path:^/documents/[^/]+", wantErr: true},
+		{spec: "path:(", wantErr: true},
+		{spec: "body", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			extractor, err := ParseExtractor(tt.spec)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSource) {
+					t.Fatalf("ParseExtractor(%q) error = %v, want %v", tt.spec, err, ErrInvalidSource)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := extractor.ExtractKey(req)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ExtractKey() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	sources := []string{"header:X-Shard-Key", "query:key"}
+
+	tests := []struct {
+		name     string
+		fallback string
+		header   string
+		url      string
+		want     string
+		wantErr  error
+	}{
+		{name: "first source", fallback: FallbackReject, header: "header-key", url: "/?key=query-key", want: "header-key"},
+		{name: "second source", fallback: FallbackReject, url: "/?key=query-key", want: "query-key"},
+		{name: "reject", fallback: FallbackReject, url: "/", wantErr: ErrNoKey},
+		{name: "remote address", fallback: FallbackRemoteAddr, url: "/", want: "10.0.0.1:54321"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline, err := NewPipeline(sources, tt.fallback)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.RemoteAddr = "10.0.0.1:54321"
+			if tt.header != "" {
+				req.Header.Set("X-Shard-Key", tt.header)
+			}
+
+			got, err := pipeline.Extract(req)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("Extract() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPipelineRandomFallback(t *testing.T) {
+	pipeline, err := NewPipeline(nil, FallbackRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	first, err := pipeline.Extract(req)
+	if err != nil || first == "" {
+		t.Fatalf("Extract() = %q, %v, want a random key", first, err)
+	}
+	if second, _ := pipeline.Extract(req); second == first {
+		t.Errorf("Extract() returned the same random key %q twice", first)
+	}
+}
+
+func TestNewPipelineInvalid(t *testing.T) {
+	if _, err := NewPipeline(nil, "sticky"); !errors.Is(err, ErrInvalidFallback) {
+		t.Errorf("NewPipeline() error = %v, want %v", err, ErrInvalidFallback)
+	}
+	if _, err := NewPipeline([]string{"header"}, FallbackRandom); !errors.Is(err, ErrInvalidSource) {
+		t.Errorf("NewPipeline() error = %v, want %v", err, ErrInvalidSource)
+	}
+}
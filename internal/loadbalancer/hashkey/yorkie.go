@@ -0,0 +1,247 @@
+package hashkey
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	SourceYorkieBody = "yorkie-body"
+
+	// YorkieServicePath is the path prefix of the Yorkie RPCs.
+	YorkieServicePath = "/yorkie.v1.YorkieService/"
+
+	// yorkieAPIKeyHeader is the header of the API key identifying the project of a request.
+	yorkieAPIKeyHeader = "X-API-Key"
+
+	// defaultMaxBodyBytes is the maximum size of a request body buffered to find the document key.
+	defaultMaxBodyBytes = 1 << 20
+
+	// envelopeHeaderLength is the length of the header of a Connect streaming or gRPC message,
+	// which consists of one byte of flags and four bytes of message length.
+	envelopeHeaderLength   = 5
+	envelopeFlagCompressed = 0x01
+)
+
+// yorkieChangePackFields is the field number of the change pack in the request
+// message of the Yorkie RPCs carrying one.
+var yorkieChangePackFields = map[string]protowire.Number{
+	"AttachDocument":  2,
+	"DetachDocument":  3,
+	"RemoveDocument":  3,
+	"PushPullChanges": 3,
+}
+
+// changePackDocumentKeyField is the field number of document_key in ChangePack.
+const changePackDocumentKeyField protowire.Number = 1
+
+// yorkieRequest is the JSON encoding of the fields of Yorkie requests used to find the document key.
+type yorkieRequest struct {
+	ChangePack struct {
+		DocumentKey string `json:"documentKey"`
+	} `json:"changePack"`
+}
+
+// YorkieBodyExtractor extracts "<api key>/<document key>" from the body of
+// Yorkie RPCs, the format of the X-Shard-Key header set by Yorkie clients. It
+// decodes Connect unary (JSON and binary protobuf), Connect streaming, gRPC and
+// gRPC-Web requests, and replays the buffered body to the backend.
+//
+// The document key is the one of the change pack of the request. WatchDocument
+// and Broadcast carry no change pack, only the document ID, so they get no key
+// from the body and rely on the X-Shard-Key header.
+type YorkieBodyExtractor struct {
+	maxBodyBytes int64
+}
+
+func NewYorkieBodyExtractor() *YorkieBodyExtractor {
+	return &YorkieBodyExtractor{
+		maxBodyBytes: defaultMaxBodyBytes,
+	}
+}
+
+func (e *YorkieBodyExtractor) ExtractKey(req *http.Request) (string, bool) {
+	if req.Method != http.MethodPost || req.Body == nil || !strings.HasPrefix(req.URL.Path, YorkieServicePath) {
+		return "", false
+	}
+
+	changePackField, ok := yorkieChangePackFields[strings.TrimPrefix(req.URL.Path, YorkieServicePath)]
+	if !ok {
+		return "", false
+	}
+
+	message, ok := e.readMessage(req)
+	if !ok {
+		return "", false
+	}
+
+	var documentKey string
+	if isJSON(req.Header.Get("Content-Type")) {
+		var r yorkieRequest
+		if err := json.Unmarshal(message, &r); err != nil {
+			return "", false
+		}
+		documentKey = r.ChangePack.DocumentKey
+	} else {
+		documentKey = decodeDocumentKey(message, changePackField)
+	}
+	if documentKey == "" {
+		return "", false
+	}
+
+	return req.Header.Get(yorkieAPIKeyHeader) + "/" + documentKey, true
+}
+
+// readMessage reads the first message of the request body and replays the read
+// bytes to the backend. The message is decompressed if it is gzip-compressed.
+func (e *YorkieBodyExtractor) readMessage(req *http.Request) ([]byte, bool) {
+	contentType := req.Header.Get("Content-Type")
+	enveloped := strings.HasPrefix(contentType, "application/grpc") ||
+		strings.HasPrefix(contentType, "application/connect+")
+	if !enveloped && contentType != "application/json" && contentType != "application/proto" {
+		return nil, false
+	}
+
+	// raw holds the bytes read from the body, which are replayed to the backend
+	var raw bytes.Buffer
+	defer replayBody(req, &raw)
+	body := io.TeeReader(req.Body, &raw)
+
+	if !enveloped {
+		if _, err := io.Copy(io.Discard, io.LimitReader(body, e.maxBodyBytes+1)); err != nil || int64(raw.Len()) > e.maxBodyBytes {
+			return nil, false
+		}
+		return decompress(raw.Bytes(), req.Header.Get("Content-Encoding"))
+	}
+
+	// gRPC-Web text bodies are base64-encoded gRPC-Web messages
+	if strings.HasPrefix(contentType, "application/grpc-web-text") {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, body, envelopeHeaderLength); err != nil {
+		return nil, false
+	}
+	flags := buf.Bytes()[0]
+	length := int64(binary.BigEndian.Uint32(buf.Bytes()[1:envelopeHeaderLength]))
+	if length > e.maxBodyBytes {
+		return nil, false
+	}
+	if _, err := io.CopyN(&buf, body, length); err != nil {
+		return nil, false
+	}
+
+	message := buf.Bytes()[envelopeHeaderLength:]
+	if flags&envelopeFlagCompressed == 0 {
+		return message, true
+	}
+
+	encoding := req.Header.Get("Grpc-Encoding")
+	if encoding == "" {
+		encoding = req.Header.Get("Connect-Content-Encoding")
+	}
+	return decompress(message, encoding)
+}
+
+// decodeDocumentKey returns the document key of the change pack of a
+// protobuf-encoded Yorkie request.
+func decodeDocumentKey(message []byte, changePackField protowire.Number) string {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return ""
+		}
+		message = message[n:]
+
+		if num == changePackField && typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(message)
+			if n < 0 {
+				return ""
+			}
+			return decodeChangePackDocumentKey(value)
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, message)
+		if n < 0 {
+			return ""
+		}
+		message = message[n:]
+	}
+
+	return ""
+}
+
+func decodeChangePackDocumentKey(message []byte) string {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return ""
+		}
+		message = message[n:]
+
+		if num == changePackDocumentKeyField && typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(message)
+			if n < 0 {
+				return ""
+			}
+			return string(value)
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, message)
+		if n < 0 {
+			return ""
+		}
+		message = message[n:]
+	}
+
+	return ""
+}
+
+func isJSON(contentType string) bool {
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+func decompress(message []byte, encoding string) ([]byte, bool) {
+	switch encoding {
+	case "", "identity":
+		return message, true
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(message))
+		if err != nil {
+			return nil, false
+		}
+		decompressed, err := io.ReadAll(io.LimitReader(reader, defaultMaxBodyBytes))
+		if err != nil {
+			return nil, false
+		}
+		return decompressed, true
+	}
+
+	return nil, false
+}
+
+// replayBody makes the request body read the buffered bytes before the rest of the original body.
+func replayBody(req *http.Request, buf *bytes.Buffer) {
+	if buf.Len() == 0 {
+		return
+	}
+
+	req.Body = &replayedBody{
+		Reader: io.MultiReader(bytes.NewReader(buf.Bytes()), req.Body),
+		Closer: req.Body,
+	}
+}
+
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,184 @@
+package hashkey
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoRequest returns a protobuf-encoded Yorkie request with a client ID, a
+// document ID and a change pack with the document key in changePackField.
+func protoRequest(changePackField protowire.Number, documentKey string) []byte {
+	var changePack []byte
+	changePack = protowire.AppendTag(changePack, changePackDocumentKeyField, protowire.BytesType)
+	changePack = protowire.AppendString(changePack, documentKey)
+	changePack = protowire.AppendTag(changePack, 2, protowire.VarintType)
+	changePack = protowire.AppendVarint(changePack, 42)
+
+	var message []byte
+	message = protowire.AppendTag(message, 1, protowire.BytesType)
+	message = protowire.AppendString(message, "client")
+	if changePackField != 2 {
+		message = protowire.AppendTag(message, 2, protowire.BytesType)
+		message = protowire.AppendString(message, "document-id")
+	}
+	message = protowire.AppendTag(message, changePackField, protowire.BytesType)
+	return protowire.AppendBytes(message, changePack)
+}
+
+// envelope returns the message in a Connect streaming or gRPC envelope.
+func envelope(flags byte, message []byte) []byte {
+	e := make([]byte, envelopeHeaderLength, envelopeHeaderLength+len(message))
+	e[0] = flags
+	binary.BigEndian.PutUint32(e[1:], uint32(len(message)))
+	return append(e, message...)
+}
+
+func gzipped(t *testing.T, message []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(message); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestYorkieBodyExtractor(t *testing.T) {
+	type request struct {
+		contentType string
+		headers     map[string]string
+		body        []byte
+	}
+	jsonRequest := func(documentKey string) request {
+		return request{
+			contentType: "application/json",
+			body:        []byte(`{"clientId":"client","documentId":"document-id","changePack":{"documentKey":"` + documentKey + `","checkpoint":{}}}`),
+		}
+	}
+	protoUnary := func(field protowire.Number) request {
+		return request{contentType: "application/proto", body: protoRequest(field, "doc")}
+	}
+	grpcFramed := func(field protowire.Number) request {
+		return request{contentType: "application/grpc", body: envelope(0, protoRequest(field, "doc"))}
+	}
+	grpcWebText := func(field protowire.Number) request {
+		body := envelope(0, protoRequest(field, "doc"))
+		return request{
+			contentType: "application/grpc-web-text",
+			body:        []byte(base64.StdEncoding.EncodeToString(body)),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		apiKey  string
+		request request
+		want    string
+		wantOK  bool
+	}{
+		{name: "attach connect json", method: "AttachDocument", apiKey: "project", request: jsonRequest("doc"), want: "project/doc", wantOK: true},
+		{name: "attach connect proto", method: "AttachDocument", apiKey: "project", request: protoUnary(2), want: "project/doc", wantOK: true},
+		{name: "attach grpc", method: "AttachDocument", apiKey: "project", request: grpcFramed(2), want: "project/doc", wantOK: true},
+		{name: "attach grpc-web-text", method: "AttachDocument", apiKey: "project", request: grpcWebText(2), want: "project/doc", wantOK: true},
+		{name: "detach connect json", method: "DetachDocument", apiKey: "project", request: jsonRequest("doc"), want: "project/doc", wantOK: true},
+		{name: "detach connect proto", method: "DetachDocument", apiKey: "project", request: protoUnary(3), want: "project/doc", wantOK: true},
+		{name: "detach grpc", method: "DetachDocument", apiKey: "project", request: grpcFramed(3), want: "project/doc", wantOK: true},
+		{name: "remove connect json", method: "RemoveDocument", apiKey: "project", request: jsonRequest("doc"), want: "project/doc", wantOK: true},
+		{name: "remove connect proto", method: "RemoveDocument", apiKey: "project", request: protoUnary(3), want: "project/doc", wantOK: true},
+		{name: "remove grpc", method: "RemoveDocument", apiKey: "project", request: grpcFramed(3), want: "project/doc", wantOK: true},
+		{name: "push pull connect json", method: "PushPullChanges", apiKey: "project", request: jsonRequest("doc"), want: "project/doc", wantOK: true},
+		{name: "push pull connect proto", method: "PushPullChanges", apiKey: "project", request: protoUnary(3), want: "project/doc", wantOK: true},
+		{name: "push pull grpc", method: "PushPullChanges", apiKey: "project", request: grpcFramed(3), want: "project/doc", wantOK: true},
+		{name: "push pull grpc-web-text", method: "PushPullChanges", apiKey: "project", request: grpcWebText(3), want: "project/doc", wantOK: true},
+		{name: "push pull connect streaming", method: "PushPullChanges", apiKey: "project", request: request{
+			contentType: "application/connect+proto",
+			body:        envelope(0, protoRequest(3, "doc")),
+		}, want: "project/doc", wantOK: true},
+		{name: "push pull gzip grpc", method: "PushPullChanges", apiKey: "project", request: request{
+			contentType: "application/grpc",
+			headers:     map[string]string{"Grpc-Encoding": "gzip"},
+			body:        envelope(envelopeFlagCompressed, gzipped(t, protoRequest(3, "doc"))),
+		}, want: "project/doc", wantOK: true},
+		{name: "push pull gzip connect json", method: "PushPullChanges", apiKey: "project", request: request{
+			contentType: "application/json",
+			headers:     map[string]string{"Content-Encoding": "gzip"},
+			body:        gzipped(t, jsonRequest("doc").body),
+		}, want: "project/doc", wantOK: true},
+		{name: "without api key", method: "PushPullChanges", request: grpcFramed(3), want: "/doc", wantOK: true},
+		{name: "watch connect json", method: "WatchDocument", apiKey: "project", request: request{
+			contentType: "application/json",
+			body:        []byte(`{"clientId":"client","documentId":"document-id"}`),
+		}},
+		{name: "broadcast grpc", method: "Broadcast", apiKey: "project", request: grpcFramed(2)},
+		{name: "unknown method", method: "ActivateClient", apiKey: "project", request: grpcFramed(3)},
+		{name: "empty document key", method: "PushPullChanges", apiKey: "project", request: jsonRequest("")},
+		{name: "unknown content type", method: "PushPullChanges", apiKey: "project", request: request{
+			contentType: "text/plain",
+			body:        protoRequest(3, "doc"),
+		}},
+		{name: "truncated envelope", method: "PushPullChanges", apiKey: "project", request: request{
+			contentType: "application/grpc",
+			body:        envelope(0, protoRequest(3, "doc"))[:10],
+		}},
+		{name: "malformed proto", method: "PushPullChanges", apiKey: "project", request: request{
+			contentType: "application/proto",
+			body:        []byte{0x1a, 0xff},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, YorkieServicePath+tt.method, bytes.NewReader(tt.request.body))
+			req.Header.Set("Content-Type", tt.request.contentType)
+			for name, value := range tt.request.headers {
+				req.Header.Set(name, value)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set(yorkieAPIKeyHeader, tt.apiKey)
+			}
+
+			got, ok := NewYorkieBodyExtractor().ExtractKey(req)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("ExtractKey() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+
+			// the backend receives the whole body
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(body, tt.request.body) {
+				t.Errorf("replayed body = %x, want %x", body, tt.request.body)
+			}
+		})
+	}
+}
+
+func TestYorkieBodyExtractorMaxBodyBytes(t *testing.T) {
+	body := envelope(0, protoRequest(3, "doc"))
+	req := httptest.NewRequest(http.MethodPost, YorkieServicePath+"PushPullChanges", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+
+	e := &YorkieBodyExtractor{maxBodyBytes: 8}
+	if key, ok := e.ExtractKey(req); ok {
+		t.Errorf("ExtractKey() = %q, want no key for a body over the limit", key)
+	}
+	got, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("replayed body = %x, want %x", got, body)
+	}
+}
@@ -0,0 +1,100 @@
+package least_request
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/grpc"
+	"github.com/krapie/l7/internal/loadbalancer"
+)
+
+const (
+	Algorithm         = "least-request"
+	WeightedAlgorithm = "weighted-least-request"
+)
+
+func init() {
+	loadbalancer.RegisterFactory(Algorithm, func(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+		return NewLB(pool, false)
+	})
+	loadbalancer.RegisterFactory(WeightedAlgorithm, func(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+		return NewLB(pool, true)
+	})
+}
+
+// LeastRequestLB sends each request to the alive backend with the fewest
+// in-flight requests. Long-lived streams stay in-flight for their whole
+// lifetime, so backends holding many streams receive fewer new requests.
+type LeastRequestLB struct {
+	pool            *loadbalancer.BackendPool
+	backendRegistry *registry.BackendRegistry
+
+	// weighted divides the in-flight requests of each backend by its weight.
+	weighted bool
+}
+
+func NewLB(pool *loadbalancer.BackendPool, weighted bool) (*LeastRequestLB, error) {
+	return &LeastRequestLB{
+		pool:            pool,
+		backendRegistry: pool.Registry,
+
+		weighted: weighted,
+	}, nil
+}
+
+// ServeProxy serves the request to the backend with the fewest in-flight requests
+// keep in mind that this function and its sub functions need to be thread safe
+func (lb *LeastRequestLB) ServeProxy(rw http.ResponseWriter, req *http.Request) {
+	if b := lb.chooseBackend(req); b != nil {
+		log.Printf("[LoadBalancer] Serving request to backend %s (active: %d)", b.Addr.String(), b.ActiveRequests())
+		lb.pool.Serve(rw, req, b)
+		return
+	}
+
+	grpc.Error(rw, req, "No backends available", http.StatusServiceUnavailable)
+}
+
+func (lb *LeastRequestLB) chooseBackend(req *http.Request) *backend.Backend {
+	backends := lb.backendRegistry.GetBackends()
+	if len(backends) == 0 {
+		return nil
+	}
+
+	// start from a random offset so that ties are not always broken in favor of the first backend
+	offset := rand.Intn(len(backends))
+
+	var chosen *backend.Backend
+	var chosenLoad float64
+	for i := 0; i < len(backends); i++ {
+		b := backends[(offset+i)%len(backends)]
+		if !loadbalancer.IsAvailable(req, b) {
+			continue
+		}
+
+		load := lb.load(b)
+		if chosen == nil || load < chosenLoad {
+			chosen = b
+			chosenLoad = load
+		}
+	}
+
+	return chosen
+}
+
+func (lb *LeastRequestLB) load(b *backend.Backend) float64 {
+	active := float64(b.ActiveRequests())
+	if !lb.weighted {
+		return active
+	}
+
+	weight := b.GetWeight()
+	if weight <= 0 {
+		weight = backend.DefaultWeight
+	}
+
+	// add one so that the weight also breaks ties between idle backends
+	return (active + 1) / float64(weight)
+}
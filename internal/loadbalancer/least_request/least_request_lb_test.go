@@ -0,0 +1,95 @@
+package least_request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/loadbalancer"
+)
+
+// holdRequests keeps n requests in flight on the backend until its server releases them.
+func holdRequests(t *testing.T, b *backend.Backend, n int, wg *sync.WaitGroup) {
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for b.ActiveRequests() < int64(n) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests in flight on %s, want %d", b.ActiveRequests(), b.ID, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestChooseBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		weighted bool
+		weights  []int
+		active   []int
+		down     []bool
+		want     string
+	}{
+		{name: "fewest requests", weights: []int{1, 1, 1}, active: []int{2, 0, 1}, want: "b"},
+		{name: "weights ignored", weights: []int{1, 4}, active: []int{1, 2}, want: "a"},
+		{name: "weighted", weighted: true, weights: []int{1, 4}, active: []int{1, 2}, want: "b"},
+		{name: "weighted idle backends", weighted: true, weights: []int{1, 3}, active: []int{0, 0}, want: "b"},
+		{name: "unavailable skipped", weights: []int{1, 1}, active: []int{0, 3}, down: []bool{true, false}, want: "b"},
+		{name: "no available backend", weights: []int{1}, active: []int{0}, down: []bool{true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				<-release
+			}))
+			var wg sync.WaitGroup
+			defer func() {
+				close(release)
+				wg.Wait()
+				server.Close()
+			}()
+
+			pool := &loadbalancer.BackendPool{Registry: registry.NewRegistry()}
+			for i, weight := range tt.weights {
+				id := string(rune('a' + i))
+				if err := pool.Registry.AddBackend(id, server.URL, weight); err != nil {
+					t.Fatal(err)
+				}
+				b, _ := pool.Registry.GetBackendByID(id)
+				holdRequests(t, b, tt.active[i], &wg)
+				if tt.down != nil {
+					b.SetAlive(!tt.down[i])
+				}
+			}
+
+			lb, err := NewLB(pool, tt.weighted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// ties are broken at random, so choose several times
+			for i := 0; i < 10; i++ {
+				b := lb.chooseBackend(httptest.NewRequest(http.MethodGet, "/", nil))
+				var got string
+				if b != nil {
+					got = b.ID
+				}
+				if got != tt.want {
+					t.Fatalf("chose %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
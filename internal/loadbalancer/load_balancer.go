@@ -1,7 +1,13 @@
 package loadbalancer
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/krapie/l7/internal/stream"
 )
 
 const (
@@ -9,7 +15,85 @@ const (
 	DiscoveryModeK8s    = "k8s"
 )
 
+var (
+	ErrUnknownAlgorithm = errors.New("unknown load balancing algorithm")
+)
+
 // LoadBalancer is an interface for a load balancer.
 type LoadBalancer interface {
 	ServeProxy(rw http.ResponseWriter, req *http.Request)
 }
+
+// Config is the configuration of a load balancing algorithm.
+type Config struct {
+	Algorithm string `mapstructure:"algorithm"`
+
+	// HashKeySources are the sources of the hash key of key-affinity
+	// algorithms, tried in order. See hashkey.ParseExtractor for the syntax.
+	HashKeySources []string `mapstructure:"hash-key-sources"`
+	// HashKeyFallback is the policy for requests without a hash key.
+	HashKeyFallback string `mapstructure:"hash-key-fallback"`
+
+	// BoundedLoadEpsilon enables consistent hashing with bounded loads if positive.
+	BoundedLoadEpsilon float64 `mapstructure:"bounded-load-epsilon"`
+
+	// MigrationConfig is the policy of moving the streams of key-affinity
+	// algorithms to the new owner of their key.
+	stream.MigrationConfig `mapstructure:",squash"`
+
+	// Retry is the policy of retrying failed requests on another backend.
+	Retry RetryConfig `mapstructure:"retry"`
+}
+
+// Factory creates a load balancer which balances requests over the given pool.
+type Factory func(pool *BackendPool, config *Config) (LoadBalancer, error)
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = make(map[string]Factory)
+)
+
+// RegisterFactory registers the factory of the given algorithm. It is meant to
+// be called from the init function of the package implementing the algorithm.
+func RegisterFactory(algorithm string, factory Factory) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+
+	if _, ok := factories[algorithm]; ok {
+		panic(fmt.Sprintf("load balancing algorithm %s registered twice", algorithm))
+	}
+	factories[algorithm] = factory
+}
+
+// Algorithms returns the names of the registered algorithms in sorted order.
+func Algorithms() []string {
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+
+	var algorithms []string
+	for algorithm := range factories {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+
+	return algorithms
+}
+
+// NewLoadBalancer creates a load balancer of the configured algorithm over the
+// given pool, which retries failed requests on another backend if its retry
+// policy allows more than one attempt.
+func NewLoadBalancer(pool *BackendPool, config *Config) (LoadBalancer, error) {
+	factoriesMutex.RLock()
+	factory, ok := factories[config.Algorithm]
+	factoriesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (available: %v)", ErrUnknownAlgorithm, config.Algorithm, Algorithms())
+	}
+
+	loadBalancer, err := factory(pool, config)
+	if err != nil || config.Retry.MaxAttempts <= 1 {
+		return loadBalancer, err
+	}
+
+	return newRetryingLoadBalancer(loadBalancer, pool, config.Retry)
+}
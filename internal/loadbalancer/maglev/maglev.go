@@ -22,6 +22,7 @@ type Maglev struct {
 	permutation [][]uint64
 	lookup      []int64
 	nodeList    []string
+	weights     map[string]int
 	lock        *sync.RWMutex
 }
 
@@ -30,7 +31,7 @@ func NewMaglev(backends []string, m uint64) (*Maglev, error) {
 	if !big.NewInt(0).SetUint64(m).ProbablyPrime(1) {
 		return nil, errors.New("[maglev] lookup table size is not a prime number")
 	}
-	mag := &Maglev{m: m, lock: &sync.RWMutex{}}
+	mag := &Maglev{m: m, weights: make(map[string]int), lock: &sync.RWMutex{}}
 	if err := mag.Set(backends); err != nil {
 		return nil, err
 	}
@@ -38,7 +39,7 @@ func NewMaglev(backends []string, m uint64) (*Maglev, error) {
 }
 
 // Add : Return nil if add success, otherwise return error
-func (m *Maglev) Add(backend string) error {
+func (m *Maglev) Add(backend string, weight int) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -51,8 +52,12 @@ func (m *Maglev) Add(backend string) error {
 	if m.m == m.n {
 		return errors.New("[maglev] number of backends would be greater than lookup table")
 	}
+	if weight <= 0 {
+		return errors.New("[maglev] weight must be positive")
+	}
 
 	m.nodeList = append(m.nodeList, backend)
+	m.weights[backend] = weight
 	m.n = uint64(len(m.nodeList))
 	m.generatePopulation()
 	m.populate()
@@ -66,11 +71,12 @@ func (m *Maglev) Remove(backend string) error {
 	defer m.lock.Unlock()
 
 	index := sort.SearchStrings(m.nodeList, backend)
-	if index == len(m.nodeList) {
+	if index == len(m.nodeList) || m.nodeList[index] != backend {
 		return errors.New("[maglev] node not found")
 	}
 
 	m.nodeList = append(m.nodeList[:index], m.nodeList[index+1:]...)
+	delete(m.weights, backend)
 
 	m.n = uint64(len(m.nodeList))
 	m.generatePopulation()
@@ -78,6 +84,26 @@ func (m *Maglev) Remove(backend string) error {
 	return nil
 }
 
+// SetWeight : Rebuild the lookup table with the new weight of the backend
+func (m *Maglev) SetWeight(backend string, weight int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.weights[backend]; !ok {
+		return errors.New("[maglev] node not found")
+	}
+	if weight <= 0 {
+		return errors.New("[maglev] weight must be positive")
+	}
+	if m.weights[backend] == weight {
+		return nil
+	}
+
+	m.weights[backend] = weight
+	m.populate()
+	return nil
+}
+
 func (m *Maglev) Set(backends []string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -88,6 +114,10 @@ func (m *Maglev) Set(backends []string) error {
 	}
 	m.nodeList = make([]string, n)
 	copy(m.nodeList, backends) // Copy to avoid modifying orinal input afterwards
+	m.weights = make(map[string]int, n)
+	for _, backend := range backends {
+		m.weights[backend] = 1
+	}
 	m.n = n
 	m.generatePopulation()
 	m.populate()
@@ -99,6 +129,7 @@ func (m *Maglev) Clear() {
 	defer m.lock.Unlock()
 
 	m.nodeList = nil
+	m.weights = make(map[string]int)
 	m.permutation = nil
 	m.lookup = nil
 }
@@ -115,6 +146,33 @@ func (m *Maglev) Get(obj string) (string, error) {
 	return m.nodeList[m.lookup[key%m.m]], nil
 }
 
+// GetCandidates : Get distinct node names by object string, in the order they
+// appear in the lookup table starting from the slot the object maps to.
+// The first candidate is the node returned by Get.
+func (m *Maglev) GetCandidates(obj string) ([]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if len(m.nodeList) == 0 {
+		return nil, errors.New("[maglev] empty")
+	}
+
+	key := m.hashKey(obj)
+	seen := make([]bool, m.n)
+	var candidates []string
+	for i := uint64(0); i < m.m && uint64(len(candidates)) < m.n; i++ {
+		index := m.lookup[(key+i)%m.m]
+		if index < 0 || seen[index] {
+			continue
+		}
+
+		seen[index] = true
+		candidates = append(candidates, m.nodeList[index])
+	}
+
+	return candidates, nil
+}
+
 func (m *Maglev) hashKey(obj string) uint64 {
 	return siphash.Hash(0xdeadbabe, 0, []byte(obj))
 }
@@ -157,21 +215,25 @@ func (m *Maglev) populate() {
 
 	var n uint64
 
+	// each backend takes as many turns per round as its weight, so that it
+	// fills the lookup table proportionally to its weight
 	for { //true
 		for i = 0; i < m.n; i++ {
-			c := m.permutation[i][next[i]]
-			for entry[c] >= 0 {
+			for turn := 0; turn < m.weights[m.nodeList[i]]; turn++ {
+				c := m.permutation[i][next[i]]
+				for entry[c] >= 0 {
+					next[i] = next[i] + 1
+					c = m.permutation[i][next[i]]
+				}
+
+				entry[c] = int64(i)
 				next[i] = next[i] + 1
-				c = m.permutation[i][next[i]]
-			}
-
-			entry[c] = int64(i)
-			next[i] = next[i] + 1
-			n++
+				n++
 
-			if n == m.m {
-				m.lookup = entry
-				return
+				if n == m.m {
+					m.lookup = entry
+					return
+				}
 			}
 		}
 
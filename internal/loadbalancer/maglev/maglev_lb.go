@@ -2,91 +2,82 @@ package maglev
 
 import (
 	"errors"
+	"expvar"
 	"log"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/krapie/l7/internal/backend"
-	"github.com/krapie/l7/internal/backend/health"
 	"github.com/krapie/l7/internal/backend/register"
-	"github.com/krapie/l7/internal/backend/register/docker"
-	"github.com/krapie/l7/internal/backend/register/k8s"
 	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/grpc"
 	"github.com/krapie/l7/internal/loadbalancer"
+	"github.com/krapie/l7/internal/loadbalancer/hashkey"
+	"github.com/krapie/l7/internal/stream"
 )
 
 const (
+	Algorithm = "maglev"
+
 	MinVirtualNodes = 65537
 )
 
-type Connection struct {
-	rw        http.ResponseWriter
-	key       string
-	backendID string
-}
+var (
+	// boundedLoadSpills counts requests sent to another candidate than the
+	// owner of their key because the owner was over capacity.
+	boundedLoadSpills = expvar.NewInt("l7_maglev_bounded_load_spills")
+)
 
-type Config struct {
-	ServiceDiscoveryMode string
-	TargetFilter         string
-	MaglevHashKey        string
+func init() {
+	loadbalancer.RegisterFactory(Algorithm, func(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+		return NewLB(pool, config)
+	})
 }
 
 type MaglevLB struct {
+	pool            *loadbalancer.BackendPool
 	backendRegistry *registry.BackendRegistry
-	backendRegister register.Register
-
-	hashKey     string
-	lookupTable *Maglev
-	// TODO(krapie): change to support multiple connection in single client
-	// TODO(krapie): handle edge cases where node removal/addition sequence differs
-	// TODO(krapie): handle concurrent map access
-	streamConnections map[string]*Connection
+
+	keyExtractor *hashkey.Pipeline
+	lookupTable  registry.Table
+
+	// boundedLoadEpsilon caps the in-flight requests of each backend at
+	// (1+ε) times the average. Bounded loads are disabled if it is zero.
+	boundedLoadEpsilon float64
+
+	// migrator moves the streams whose key moved to another backend.
+	migrator *stream.Migrator
 }
 
-func NewLB(config *Config) (*MaglevLB, error) {
+func NewLB(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (*MaglevLB, error) {
 	lookupTable, err := NewMaglev([]string{}, MinVirtualNodes)
 	if err != nil {
 		return nil, err
 	}
 
-	backendRegistry := registry.NewRegistry()
+	return NewLBWithTable(pool, config, lookupTable)
+}
 
-	var backendRegister register.Register
-	if config.ServiceDiscoveryMode == loadbalancer.DiscoveryModeK8s {
-		backendRegister, err = k8s.NewRegister()
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		backendRegister, err = docker.NewRegister()
-		if err != nil {
-			return nil, err
-		}
+// NewLBWithTable creates a key-affinity load balancer which maps keys to
+// backends with the given table instead of a maglev lookup table.
+func NewLBWithTable(pool *loadbalancer.BackendPool, config *loadbalancer.Config, lookupTable registry.Table) (*MaglevLB, error) {
+	keyExtractor, err := hashkey.NewPipeline(config.HashKeySources, config.HashKeyFallback)
+	if err != nil {
+		return nil, err
 	}
 
 	lb := &MaglevLB{
-		backendRegistry: backendRegistry,
-		backendRegister: backendRegister,
+		pool:            pool,
+		backendRegistry: pool.Registry,
 
-		hashKey:           config.MaglevHashKey,
-		lookupTable:       lookupTable,
-		streamConnections: make(map[string]*Connection),
-	}
-	lb.RunWatchEventLoop()
+		keyExtractor: keyExtractor,
+		lookupTable:  lookupTable,
 
-	backendRegister.SetTargetFilter(config.TargetFilter)
-	backendRegister.SetRegistry(backendRegistry)
-	err = backendRegister.Initialize()
-	if err != nil {
-		return nil, err
+		boundedLoadEpsilon: config.BoundedLoadEpsilon,
 	}
-
-	backendRegister.Observe()
-	log.Printf("[LoadBalancer] Running backend register")
-
-	healthChecker := health.NewHealthChecker(backendRegistry, backendRegister, 2)
-	healthChecker.Run()
-	log.Printf("[LoadBalancer] Running health check")
+	lb.migrator = stream.NewMigrator(pool.Streams, config.MigrationConfig, lb.isSplitBrained)
+	pool.Subscribe(lb.handleBackendEvent)
 
 	return lb, nil
 }
@@ -94,24 +85,61 @@ func NewLB(config *Config) (*MaglevLB, error) {
 // ServeProxy serves the request to the next backend in the list
 // keep in mind that this function and its sub functions need to be thread safe
 func (lb *MaglevLB) ServeProxy(rw http.ResponseWriter, req *http.Request) {
-	// TODO(krapie): Move key extraction from http request header to separate system
-	key := req.Header.Get(lb.hashKey)
-	if key == "" {
-		key = "default"
+	key, err := lb.keyExtractor.Extract(req)
+	if err != nil {
+		grpc.Error(rw, req, "[LoadBalancer] Hash key not found", http.StatusBadRequest)
+		return
 	}
 
-	b, err := lb.chooseBackend(key)
+	b, spilled, err := lb.chooseBackend(req, key)
 	if err != nil {
-		http.Error(rw, "[LoadBalancer] Backend not found", http.StatusServiceUnavailable)
+		grpc.Error(rw, req, "[LoadBalancer] Backend not found", http.StatusServiceUnavailable)
 		return
 	}
-	lb.storeWatchConnection(rw, req, key, b.ID)
 
 	log.Printf("[LoadBalancer] Time: %s URL: %s Backend: %s", time.Now().Format(time.RFC3339), req.URL, b.ID)
-	b.Serve(rw, req)
+	lb.pool.ServeWithKey(rw, req, b, lb, key, spilled)
 }
 
-func (lb *MaglevLB) chooseBackend(key string) (*backend.Backend, error) {
+// chooseBackend returns the backend for the key, and whether the request was
+// spilled from the owner of the key to another backend because of bounded
+// loads or because the owner failed a previous attempt of the request.
+func (lb *MaglevLB) chooseBackend(req *http.Request, key string) (*backend.Backend, bool, error) {
+	if lb.boundedLoadEpsilon > 0 {
+		return lb.chooseBoundedBackend(req, key)
+	}
+
+	b, err := lb.chooseOwnerBackend(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// retries go to the next candidate of the key
+	if loadbalancer.Tried(req, b) {
+		return lb.chooseRetryBackend(req, key)
+	}
+	return b, false, nil
+}
+
+// chooseRetryBackend returns the first candidate of the key which didn't fail
+// a previous attempt of the request.
+func (lb *MaglevLB) chooseRetryBackend(req *http.Request, key string) (*backend.Backend, bool, error) {
+	candidateIDs, err := lb.lookupTable.GetCandidates(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, backendID := range candidateIDs {
+		b, exists := lb.backendRegistry.GetBackendByID(backendID)
+		if exists && loadbalancer.IsAvailable(req, b) {
+			return b, true, nil
+		}
+	}
+
+	return nil, false, errors.New("no backends available")
+}
+
+func (lb *MaglevLB) chooseOwnerBackend(key string) (*backend.Backend, error) {
 	for i := 0; i < lb.backendRegistry.Len(); i++ {
 		backendID, err := lb.lookupTable.Get(key)
 		if err != nil {
@@ -123,7 +151,7 @@ func (lb *MaglevLB) chooseBackend(key string) (*backend.Backend, error) {
 			return nil, errors.New("backend not found")
 		}
 
-		if b.IsAlive() {
+		if b.IsAvailable() {
 			return b, nil
 		}
 
@@ -136,83 +164,104 @@ func (lb *MaglevLB) chooseBackend(key string) (*backend.Backend, error) {
 	return nil, errors.New("no backends available")
 }
 
-func (lb *MaglevLB) storeWatchConnection(rw http.ResponseWriter, req *http.Request, key string, backendID string) {
-	if req.URL.Path == "/yorkie.v1.YorkieService/WatchDocument" {
-		lb.streamConnections[req.RemoteAddr] = &Connection{
-			rw:        rw,
-			key:       key,
-			backendID: backendID,
-		}
+// chooseBoundedBackend implements consistent hashing with bounded loads. Each
+// backend accepts at most ceil((1+ε) * average load) in-flight requests, and a
+// key whose owner is over capacity spills to the next candidate of the lookup table.
+func (lb *MaglevLB) chooseBoundedBackend(req *http.Request, key string) (*backend.Backend, bool, error) {
+	candidateIDs, err := lb.lookupTable.GetCandidates(key)
+	if err != nil {
+		return nil, false, err
 	}
-}
 
-func (lb *MaglevLB) RunWatchEventLoop() {
-	go lb.watchBackendEvent()
-}
-
-func (lb *MaglevLB) watchBackendEvent() {
-	eventChannel := lb.backendRegister.GetEventChannel()
-	for {
-		select {
-		case event := <-eventChannel:
-			switch event.EventType {
-			case register.BackendAddedEvent:
-				err := lb.lookupTable.Add(event.Actor)
-				if err != nil {
-					log.Printf("[LoadBalancer] Error adding backend to lookup table: %s", err)
-				}
-				lb.closeSplitBrainedConnection()
-			case register.BackendRemovedEvent:
-				err := lb.lookupTable.Remove(event.Actor)
-				if err != nil {
-					log.Printf("[LoadBalancer] Error removing backend from lookup table: %s", err)
-				}
-				lb.removeConnectionOfRemovedBackend(event.Actor)
-			}
+	var candidates []*backend.Backend
+	var totalLoad int64
+	retried := false
+	for _, backendID := range candidateIDs {
+		b, exists := lb.backendRegistry.GetBackendByID(backendID)
+		if !exists || !b.IsAvailable() {
+			continue
+		}
+		if loadbalancer.Tried(req, b) {
+			retried = true
+			continue
 		}
+
+		candidates = append(candidates, b)
+		totalLoad += b.ActiveRequests()
+	}
+	if len(candidates) == 0 {
+		return nil, false, errors.New("no backends available")
 	}
-}
 
-func (lb *MaglevLB) removeConnectionOfRemovedBackend(backendID string) {
-	for k, c := range lb.streamConnections {
-		if c.backendID == backendID {
-			delete(lb.streamConnections, k)
+	// count the new request in the average so that an idle cluster has a capacity of at least one
+	capacity := int64(math.Ceil((1 + lb.boundedLoadEpsilon) * float64(totalLoad+1) / float64(len(candidates))))
+	owner := candidates[0]
+	for _, b := range candidates {
+		if b.ActiveRequests() >= capacity {
+			continue
+		}
+
+		if b != owner {
+			boundedLoadSpills.Add(1)
+			log.Printf("[LoadBalancer] Key %s spilled from %s (load: %d, capacity: %d) to %s", key, owner.ID, owner.ActiveRequests(), capacity, b.ID)
 		}
+		return b, b != owner || retried, nil
 	}
+
+	// every candidate is over capacity because of concurrent requests, fall back to the owner
+	return owner, retried, nil
 }
 
-func (lb *MaglevLB) closeSplitBrainedConnection() {
-	for k, c := range lb.streamConnections {
-		backendID, err := lb.lookupTable.Get(c.key)
+func (lb *MaglevLB) handleBackendEvent(event register.BackendEvent) {
+	switch event.EventType {
+	case register.BackendAddedEvent:
+		err := lb.lookupTable.Add(event.Actor, lb.backendWeight(event.Actor))
 		if err != nil {
-			log.Printf("[LoadBalancer] Error getting backend from lookup table: %s", err)
-			continue
+			log.Printf("[LoadBalancer] Error adding backend to lookup table: %s", err)
+		}
+		lb.closeSplitBrainedConnection()
+	case register.BackendWeightChangedEvent:
+		err := lb.lookupTable.SetWeight(event.Actor, lb.backendWeight(event.Actor))
+		if err != nil {
+			log.Printf("[LoadBalancer] Error updating backend weight in lookup table: %s", err)
 		}
-		if backendID != c.backendID {
-			err = resetConnection(c.rw)
-			if err != nil {
-				log.Printf("[LoadBalancer] Error resetting connection: %s", err)
-				continue
-			}
-			delete(lb.streamConnections, k)
+		lb.closeSplitBrainedConnection()
+	case register.BackendRemovedEvent:
+		err := lb.lookupTable.Remove(event.Actor)
+		if err != nil {
+			log.Printf("[LoadBalancer] Error removing backend from lookup table: %s", err)
 		}
 	}
 }
 
-func resetConnection(rw http.ResponseWriter) error {
-	hj, ok := rw.(http.Hijacker)
-	if !ok {
-		return errors.New("http.ResponseWriter does not support hijacking")
+func (lb *MaglevLB) backendWeight(backendID string) int {
+	b, exists := lb.backendRegistry.GetBackendByID(backendID)
+	if !exists {
+		return backend.DefaultWeight
 	}
-	conn, _, err := hj.Hijack()
-	if err != nil {
-		return err
+
+	return b.GetWeight()
+}
+
+// closeSplitBrainedConnection moves the streams of the load balancer whose key
+// moved to another backend, so that their clients reconnect to the new owner.
+func (lb *MaglevLB) closeSplitBrainedConnection() {
+	lb.migrator.Rebalance()
+}
+
+// isSplitBrained returns whether the stream of the load balancer is not served
+// by the owner of its key.
+func (lb *MaglevLB) isSplitBrained(s *stream.Stream) bool {
+	// spilled streams are not on the owner of their key by design
+	if s.Owner != lb || s.Spilled {
+		return false
 	}
 
-	err = conn.Close()
+	backendID, err := lb.lookupTable.Get(s.Key)
 	if err != nil {
-		return err
+		log.Printf("[LoadBalancer] Error getting backend from lookup table: %s", err)
+		return false
 	}
 
-	return nil
+	return backendID != s.BackendID
 }
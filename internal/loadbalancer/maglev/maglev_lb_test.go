@@ -0,0 +1,104 @@
+package maglev
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/loadbalancer"
+	"github.com/krapie/l7/internal/loadbalancer/hashkey"
+	"github.com/krapie/l7/internal/stream"
+)
+
+// holdRequests keeps n requests in flight on the backend until release is closed.
+func holdRequests(t *testing.T, b *backend.Backend, n int, wg *sync.WaitGroup) {
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for b.ActiveRequests() < int64(n) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests in flight on %s, want %d", b.ActiveRequests(), b.ID, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestChooseBoundedBackend(t *testing.T) {
+	tests := []struct {
+		name        string
+		epsilon     float64
+		ownerLoad   int
+		ownerDown   bool
+		wantOwner   bool
+		wantSpilled bool
+	}{
+		{name: "idle owner", epsilon: 0.25, wantOwner: true},
+		{name: "owner under capacity", epsilon: 0.25, ownerLoad: 1, wantOwner: true},
+		{name: "owner over capacity", epsilon: 0.25, ownerLoad: 2, wantSpilled: true},
+		{name: "larger epsilon", epsilon: 1, ownerLoad: 2, wantOwner: true},
+		// the next candidate owns the key while its owner is down
+		{name: "owner down", epsilon: 0.25, ownerDown: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				<-release
+			}))
+			var wg sync.WaitGroup
+			defer func() {
+				close(release)
+				wg.Wait()
+				server.Close()
+			}()
+
+			pool := &loadbalancer.BackendPool{Registry: registry.NewRegistry(), Streams: stream.NewRegistry()}
+			table, err := NewMaglev(nil, MinVirtualNodes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"a", "b"} {
+				if err := pool.Registry.AddBackend(id, server.URL, 1); err != nil {
+					t.Fatal(err)
+				}
+				if err := table.Add(id, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lb, err := NewLBWithTable(pool, &loadbalancer.Config{
+				HashKeySources:     []string{"header:X-Shard-Key"},
+				HashKeyFallback:    hashkey.FallbackRandom,
+				BoundedLoadEpsilon: tt.epsilon,
+			}, table)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const key = "key"
+			ownerID, _ := table.Get(key)
+			owner, _ := pool.Registry.GetBackendByID(ownerID)
+			holdRequests(t, owner, tt.ownerLoad, &wg)
+			owner.SetAlive(!tt.ownerDown)
+
+			b, spilled, err := lb.chooseBoundedBackend(httptest.NewRequest(http.MethodGet, "/", nil), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (b == owner) != tt.wantOwner || spilled != tt.wantSpilled {
+				t.Errorf("chose %s spilled %v, want owner %v spilled %v (owner %s)", b.ID, spilled, tt.wantOwner, tt.wantSpilled, owner.ID)
+			}
+		})
+	}
+}
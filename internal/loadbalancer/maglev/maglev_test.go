@@ -0,0 +1,148 @@
+package maglev
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestMaglevPopulate(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+	}{
+		{name: "single backend", weights: map[string]int{"a": 1}},
+		{name: "equal weights", weights: map[string]int{"a": 1, "b": 1, "c": 1}},
+		{name: "double weight", weights: map[string]int{"a": 1, "b": 2}},
+		{name: "mixed weights", weights: map[string]int{"a": 1, "b": 2, "c": 3, "d": 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMaglev(nil, MinVirtualNodes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			totalWeight := 0
+			for backend, weight := range tt.weights {
+				if err := m.Add(backend, weight); err != nil {
+					t.Fatal(err)
+				}
+				totalWeight += weight
+			}
+
+			slots := make(map[string]int)
+			for _, index := range m.lookup {
+				if index < 0 {
+					t.Fatal("lookup table has an empty slot")
+				}
+				slots[m.nodeList[index]]++
+			}
+
+			// each backend fills the table in proportion to its weight
+			for backend, weight := range tt.weights {
+				share := float64(slots[backend]) / float64(len(m.lookup))
+				want := float64(weight) / float64(totalWeight)
+				if math.Abs(share-want) > 0.01 {
+					t.Errorf("share of %s = %.3f, want %.3f", backend, share, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMaglevSetWeight(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b"}, MinVirtualNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetWeight("b", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var b int
+	for _, index := range m.lookup {
+		if m.nodeList[index] == "b" {
+			b++
+		}
+	}
+	if share := float64(b) / float64(len(m.lookup)); math.Abs(share-0.75) > 0.01 {
+		t.Errorf("share of b = %.3f, want 0.75", share)
+	}
+
+	if err := m.SetWeight("c", 1); err == nil {
+		t.Error("SetWeight() of an unknown backend succeeded")
+	}
+	if err := m.SetWeight("a", 0); err == nil {
+		t.Error("SetWeight() with a zero weight succeeded")
+	}
+}
+
+func TestMaglevRemove(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d"}, MinVirtualNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owners := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners[key], _ = m.Get(key)
+	}
+
+	if err := m.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the keys of the removed backend move, give or take the few slots
+	// maglev reshuffles
+	moved := 0
+	for key, owner := range owners {
+		got, _ := m.Get(key)
+		if got == "c" {
+			t.Fatalf("key %s still maps to the removed backend", key)
+		}
+		if owner != "c" && got != owner {
+			moved++
+		}
+	}
+	if moved > len(owners)/20 {
+		t.Errorf("%d keys of remaining backends moved", moved)
+	}
+}
+
+func TestMaglevGetCandidates(t *testing.T) {
+	m, err := NewMaglev([]string{"a", "b", "c", "d"}, MinVirtualNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		candidates, err := m.GetCandidates(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != 4 {
+			t.Fatalf("GetCandidates(%q) = %v, want every backend", key, candidates)
+		}
+
+		owner, _ := m.Get(key)
+		if candidates[0] != owner {
+			t.Errorf("first candidate of %q = %s, want its owner %s", key, candidates[0], owner)
+		}
+
+		seen := make(map[string]bool)
+		for _, candidate := range candidates {
+			if seen[candidate] {
+				t.Errorf("GetCandidates(%q) = %v, want distinct backends", key, candidates)
+			}
+			seen[candidate] = true
+		}
+	}
+
+	m.Clear()
+	if _, err := m.GetCandidates("key"); err == nil {
+		t.Error("GetCandidates() of an empty table succeeded")
+	}
+}
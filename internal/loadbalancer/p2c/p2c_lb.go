@@ -0,0 +1,82 @@
+package p2c
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/grpc"
+	"github.com/krapie/l7/internal/loadbalancer"
+)
+
+const (
+	Algorithm = "p2c"
+)
+
+func init() {
+	loadbalancer.RegisterFactory(Algorithm, func(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+		return NewLB(pool)
+	})
+}
+
+// P2CLB is a power-of-two-choices load balancer. It samples two alive backends
+// at random and sends the request to the one with the lower cost, which is its
+// peak EWMA latency multiplied by its in-flight requests. A backend with a
+// latency spike is avoided as soon as its slow responses are observed.
+type P2CLB struct {
+	pool            *loadbalancer.BackendPool
+	backendRegistry *registry.BackendRegistry
+}
+
+func NewLB(pool *loadbalancer.BackendPool) (*P2CLB, error) {
+	return &P2CLB{
+		pool:            pool,
+		backendRegistry: pool.Registry,
+	}, nil
+}
+
+// ServeProxy serves the request to the cheaper of two randomly chosen backends
+// keep in mind that this function and its sub functions need to be thread safe
+func (lb *P2CLB) ServeProxy(rw http.ResponseWriter, req *http.Request) {
+	if b := lb.chooseBackend(req); b != nil {
+		log.Printf("[LoadBalancer] Serving request to backend %s (latency: %s, active: %d)", b.Addr.String(), b.Latency(), b.ActiveRequests())
+		lb.pool.Serve(rw, req, b)
+		return
+	}
+
+	grpc.Error(rw, req, "No backends available", http.StatusServiceUnavailable)
+}
+
+func (lb *P2CLB) chooseBackend(req *http.Request) *backend.Backend {
+	var alive []*backend.Backend
+	for _, b := range lb.backendRegistry.GetBackends() {
+		if loadbalancer.IsAvailable(req, b) {
+			alive = append(alive, b)
+		}
+	}
+
+	switch len(alive) {
+	case 0:
+		return nil
+	case 1:
+		return alive[0]
+	}
+
+	i := rand.Intn(len(alive))
+	j := rand.Intn(len(alive) - 1)
+	if j >= i {
+		j++
+	}
+
+	if cost(alive[j]) < cost(alive[i]) {
+		return alive[j]
+	}
+	return alive[i]
+}
+
+// cost estimates the time the backend would take to serve a new request.
+func cost(b *backend.Backend) float64 {
+	return float64(b.Latency()) * float64(b.ActiveRequests()+1)
+}
@@ -0,0 +1,92 @@
+package p2c
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/loadbalancer"
+)
+
+func TestChooseBackend(t *testing.T) {
+	fast := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	release := make(chan struct{})
+	blocking := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	var wg sync.WaitGroup
+	defer func() {
+		close(release)
+		wg.Wait()
+		blocking.Close()
+	}()
+
+	tests := []struct {
+		name string
+		// addrs are the servers of backends a and b, which serve one request
+		// each to measure their latency unless they block
+		addrs []string
+		down  []bool
+		want  string
+	}{
+		{name: "lower latency", addrs: []string{slow.URL, fast.URL}, want: "b"},
+		{name: "fewer in-flight requests", addrs: []string{blocking.URL, fast.URL}, want: "b"},
+		{name: "single available backend", addrs: []string{fast.URL, slow.URL}, down: []bool{true, false}, want: "b"},
+		{name: "no available backend", addrs: []string{fast.URL}, down: []bool{true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &loadbalancer.BackendPool{Registry: registry.NewRegistry()}
+			for i, addr := range tt.addrs {
+				id := string(rune('a' + i))
+				if err := pool.Registry.AddBackend(id, addr, 1); err != nil {
+					t.Fatal(err)
+				}
+				b, _ := pool.Registry.GetBackendByID(id)
+
+				if addr == blocking.URL {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						b.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+					}()
+					for b.ActiveRequests() == 0 {
+						time.Sleep(time.Millisecond)
+					}
+				} else {
+					b.Serve(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+				}
+				if tt.down != nil {
+					b.SetAlive(!tt.down[i])
+				}
+			}
+
+			lb, err := NewLB(pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// two backends are always both sampled, whatever the random choice
+			for i := 0; i < 10; i++ {
+				b := lb.chooseBackend(httptest.NewRequest(http.MethodGet, "/", nil))
+				var got string
+				if b != nil {
+					got = b.ID
+				}
+				if got != tt.want {
+					t.Fatalf("chose %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/health"
+	"github.com/krapie/l7/internal/backend/outlier"
+	"github.com/krapie/l7/internal/backend/register"
+	"github.com/krapie/l7/internal/backend/register/docker"
+	"github.com/krapie/l7/internal/backend/register/k8s"
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/stream"
+)
+
+const (
+	// DefaultPool is the name of the pool configured from the command line flags.
+	DefaultPool = "default"
+)
+
+// PoolConfig is the configuration of a backend pool.
+type PoolConfig struct {
+	Name                 string `mapstructure:"name"`
+	ServiceDiscoveryMode string `mapstructure:"service-discovery-mode"`
+	TargetFilter         string `mapstructure:"target-filter"`
+	// BackendProtocol is the protocol of the requests to backends, one of http1,
+	// https, h2 and h2c. It is http1 if empty.
+	BackendProtocol string `mapstructure:"backend-protocol"`
+	// Transport is the configuration of the connections to the backends,
+	// including TLS for the https and h2 protocols.
+	Transport backend.TransportConfig `mapstructure:"transport"`
+	// HealthCheck is the health check of the backends, a TCP dial if not configured.
+	HealthCheck health.Config `mapstructure:"health-check"`
+	// OutlierDetection ejects the backends failing proxied requests, disabled if
+	// not configured.
+	OutlierDetection outlier.Config `mapstructure:"outlier-detection"`
+	// CircuitBreaker limits the requests to each backend, unlimited if not configured.
+	CircuitBreaker backend.CircuitBreakerConfig `mapstructure:"circuit-breaker"`
+	// RetryBudget limits the retries of the routes of the pool, 20% of its
+	// active requests but at least 3 if not configured.
+	RetryBudget RetryBudgetConfig `mapstructure:"retry-budget"`
+}
+
+// BackendPool is a set of backends discovered by a register and checked by a
+// health checker. Load balancers pick backends from the pool's registry,
+// subscribe to its backend events, and serve requests with Serve so that the
+// pool tracks long-lived streams.
+type BackendPool struct {
+	Registry *registry.BackendRegistry
+	Register register.Register
+	Streams  *stream.Registry
+
+	healthChecker   *health.Checker
+	outlierDetector *outlier.Detector
+
+	// retries is the number of in-flight retries counted against the retry budget.
+	retries     int64
+	retryBudget RetryBudgetConfig
+
+	mutex       sync.RWMutex
+	subscribers []func(event register.BackendEvent)
+}
+
+// NewBackendPool creates a backend pool with the register of the configured
+// service discovery mode. The pool does not discover backends until Start is called.
+func NewBackendPool(config *PoolConfig) (*BackendPool, error) {
+	backendRegistry := registry.NewRegistry()
+	if err := backendRegistry.SetProtocol(config.BackendProtocol, &config.Transport); err != nil {
+		return nil, err
+	}
+	if err := backendRegistry.SetCircuitBreaker(config.CircuitBreaker); err != nil {
+		return nil, fmt.Errorf("pool %s: %w", config.Name, err)
+	}
+
+	var backendRegister register.Register
+	var err error
+	if config.ServiceDiscoveryMode == DiscoveryModeK8s {
+		backendRegister, err = k8s.NewRegister()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		backendRegister, err = docker.NewRegister()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	healthChecker, err := health.NewHealthChecker(backendRegistry, backendRegister, &config.HealthCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	var outlierDetector *outlier.Detector
+	if config.OutlierDetection.Enabled() {
+		outlierDetector = outlier.NewDetector(backendRegistry, backendRegister, &config.OutlierDetection)
+		backendRegistry.SetResultObserver(outlierDetector.Observe)
+	}
+
+	backendRegister.SetTargetFilter(config.TargetFilter)
+	backendRegister.SetRegistry(backendRegistry)
+
+	retryBudget := config.RetryBudget
+	if retryBudget.BudgetPercent <= 0 {
+		retryBudget.BudgetPercent = DefaultRetryBudgetPercent
+	}
+	if retryBudget.MinRetryConcurrency <= 0 {
+		retryBudget.MinRetryConcurrency = DefaultMinRetryConcurrency
+	}
+
+	return &BackendPool{
+		Registry: backendRegistry,
+		Register: backendRegister,
+		Streams:  stream.NewRegistry(),
+
+		healthChecker:   healthChecker,
+		outlierDetector: outlierDetector,
+
+		retryBudget: retryBudget,
+	}, nil
+}
+
+// Subscribe registers the handler to be called for every backend event of the pool.
+// Handlers are called sequentially from a single goroutine.
+func (p *BackendPool) Subscribe(handler func(event register.BackendEvent)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.subscribers = append(p.subscribers, handler)
+}
+
+// Start initializes the register, and starts observing backends, checking
+// their health and detecting outliers.
+func (p *BackendPool) Start() error {
+	go p.dispatchBackendEvent()
+
+	if err := p.Register.Initialize(); err != nil {
+		return err
+	}
+
+	p.Register.Observe()
+	log.Printf("[LoadBalancer] Running backend register")
+
+	p.healthChecker.Run()
+	log.Printf("[LoadBalancer] Running health check")
+
+	if p.outlierDetector != nil {
+		p.outlierDetector.Run()
+		log.Printf("[LoadBalancer] Running outlier detection")
+	}
+
+	return nil
+}
+
+// SetBackendWeight changes the weight of the backend and notifies the load balancers
+// of the pool, so that they rebalance without removing the backend.
+func (p *BackendPool) SetBackendWeight(backendID string, weight int) error {
+	changed, err := p.Registry.SetBackendWeight(backendID, weight)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	p.Register.GetEventChannel() <- register.BackendEvent{
+		EventType: register.BackendWeightChangedEvent,
+		Actor:     backendID,
+	}
+	return nil
+}
+
+func (p *BackendPool) dispatchBackendEvent() {
+	for event := range p.Register.GetEventChannel() {
+		p.mutex.RLock()
+		subscribers := p.subscribers
+		p.mutex.RUnlock()
+
+		for _, handler := range subscribers {
+			handler(event)
+		}
+
+		if event.EventType == register.BackendRemovedEvent {
+			p.closeStreamsOf(event.Actor)
+
+			// forget the outlier state of the backends removed from the registry
+			// rather than failing or ejected
+			if _, ok := p.Registry.GetBackendByID(event.Actor); !ok && p.outlierDetector != nil {
+				p.outlierDetector.Forget(event.Actor)
+			}
+		}
+	}
+}
+
+// Serve proxies the request to the backend, tracking it while it is served if
+// it is a stream.
+func (p *BackendPool) Serve(rw http.ResponseWriter, req *http.Request, b *backend.Backend) {
+	p.ServeWithKey(rw, req, b, nil, "", false)
+}
+
+// ServeWithKey proxies the request of a key-affinity load balancer to the
+// backend. Streams are tracked with the load balancer as owner and the hash
+// key, so that the owner can move them when their key moves.
+func (p *BackendPool) ServeWithKey(rw http.ResponseWriter, req *http.Request, b *backend.Backend, owner interface{}, key string, spilled bool) {
+	if !stream.IsStream(req) {
+		b.Serve(rw, req)
+		return
+	}
+
+	track := func(close func() error) *stream.Stream {
+		s := stream.New(req, b.ID, close)
+		s.Owner = owner
+		s.Key = key
+		s.Spilled = spilled
+		p.Streams.Add(s)
+		return s
+	}
+
+	// track upgraded connections once the backend accepts the upgrade
+	if backend.IsUpgrade(req) {
+		var s *stream.Stream
+		defer func() {
+			if s != nil {
+				p.Streams.Remove(s)
+			}
+		}()
+
+		b.ServeUpgrade(rw, req, backend.IdleTimeout(req.Context()), func(conn *backend.UpgradedConn) {
+			s = track(func() error {
+				conn.Close(backend.CloseServiceRestart)
+				return nil
+			})
+		})
+		return
+	}
+
+	// closing the stream cancels its proxying, and its response is then ended
+	// in a way its client recognizes as retryable
+	ctx, cancel := context.WithCancelCause(req.Context())
+	defer cancel(nil)
+
+	s := track(func() error {
+		cancel(stream.ErrMoved)
+		return nil
+	})
+	defer p.Streams.Remove(s)
+
+	defer func() {
+		// the proxy aborts the handler when its response is interrupted, which
+		// resets the stream (RST_STREAM) unless it was ended by l7, such as when
+		// it moved or timed out, and the protocol can end it
+		if r := recover(); r != nil {
+			cause := context.Cause(ctx)
+			ended := errors.Is(cause, stream.ErrMoved) || backend.IsTimeout(cause)
+			if r != http.ErrAbortHandler || !ended || !stream.Terminate(rw, req, cause.Error()) {
+				panic(r)
+			}
+		}
+	}()
+
+	b.Serve(rw, req.WithContext(ctx))
+}
+
+// closeStreamsOf closes the streams of the removed backend, so that their
+// clients reconnect to another backend. They are closed off the dispatcher, so
+// that a client slow to take its close frame doesn't hold the backend events.
+func (p *BackendPool) closeStreamsOf(backendID string) {
+	streams := p.Streams.StreamsOf(backendID)
+	if len(streams) == 0 {
+		return
+	}
+
+	go func() {
+		for _, s := range streams {
+			if err := s.Close(); err != nil {
+				log.Printf("[LoadBalancer] Error closing stream: %s", err)
+			}
+			p.Streams.Remove(s)
+		}
+	}()
+}
@@ -0,0 +1,128 @@
+package rendezvous
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/dchest/siphash"
+)
+
+// Rendezvous is a highest random weight table. Every backend scores each key
+// and the key is owned by the backend with the highest score. It keeps no
+// table at all, and removing a backend only moves the keys it owned, at the
+// cost of scoring every backend on each lookup.
+type Rendezvous struct {
+	weights map[string]int
+	lock    sync.RWMutex
+}
+
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{
+		weights: make(map[string]int),
+	}
+}
+
+// Add : Return nil if add success, otherwise return error
+func (r *Rendezvous) Add(backend string, weight int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.weights[backend]; ok {
+		return errors.New("[rendezvous] exist already")
+	}
+	if weight <= 0 {
+		return errors.New("[rendezvous] weight must be positive")
+	}
+
+	r.weights[backend] = weight
+	return nil
+}
+
+// Remove : Return nil if remove success, otherwise return error
+func (r *Rendezvous) Remove(backend string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.weights[backend]; !ok {
+		return errors.New("[rendezvous] node not found")
+	}
+
+	delete(r.weights, backend)
+	return nil
+}
+
+// SetWeight : Return nil if the weight of the backend is changed, otherwise return error
+func (r *Rendezvous) SetWeight(backend string, weight int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.weights[backend]; !ok {
+		return errors.New("[rendezvous] node not found")
+	}
+	if weight <= 0 {
+		return errors.New("[rendezvous] weight must be positive")
+	}
+
+	r.weights[backend] = weight
+	return nil
+}
+
+// Get : Get node name by object string.
+func (r *Rendezvous) Get(obj string) (string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.weights) == 0 {
+		return "", errors.New("[rendezvous] empty")
+	}
+
+	var owner string
+	var ownerScore float64
+	for backend, weight := range r.weights {
+		s := score(obj, backend, weight)
+		if owner == "" || s > ownerScore || (s == ownerScore && backend < owner) {
+			owner = backend
+			ownerScore = s
+		}
+	}
+
+	return owner, nil
+}
+
+// GetCandidates : Get every node name by object string, ordered by descending score.
+func (r *Rendezvous) GetCandidates(obj string) ([]string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.weights) == 0 {
+		return nil, errors.New("[rendezvous] empty")
+	}
+
+	candidates := make([]string, 0, len(r.weights))
+	scores := make(map[string]float64, len(r.weights))
+	for backend, weight := range r.weights {
+		candidates = append(candidates, backend)
+		scores[backend] = score(obj, backend, weight)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if scores[candidates[i]] == scores[candidates[j]] {
+			return candidates[i] < candidates[j]
+		}
+		return scores[candidates[i]] > scores[candidates[j]]
+	})
+
+	return candidates, nil
+}
+
+// score is the weighted rendezvous score -weight/ln(h) of the backend for the
+// object, where h is the hash of both mapped to (0, 1). Each backend wins a
+// share of the keys proportional to its weight.
+func score(obj, backend string, weight int) float64 {
+	hash := siphash.Hash(0xdeadbabe, 0, []byte(backend+"\x00"+obj))
+	h := (float64(hash>>11) + 0.5) / (1 << 53)
+
+	return -float64(weight) / math.Log(h)
+}
@@ -0,0 +1,16 @@
+package rendezvous
+
+import (
+	"github.com/krapie/l7/internal/loadbalancer"
+	"github.com/krapie/l7/internal/loadbalancer/maglev"
+)
+
+const (
+	Algorithm = "rendezvous"
+)
+
+func init() {
+	loadbalancer.RegisterFactory(Algorithm, func(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+		return maglev.NewLBWithTable(pool, config, NewRendezvous())
+	})
+}
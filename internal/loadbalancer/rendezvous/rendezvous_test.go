@@ -0,0 +1,112 @@
+package rendezvous
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+const keys = 20000
+
+func TestRendezvousDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+	}{
+		{name: "equal weights", weights: map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}},
+		{name: "double weight", weights: map[string]int{"a": 1, "b": 2}},
+		{name: "mixed weights", weights: map[string]int{"a": 1, "b": 2, "c": 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRendezvous()
+			totalWeight := 0
+			for backend, weight := range tt.weights {
+				if err := r.Add(backend, weight); err != nil {
+					t.Fatal(err)
+				}
+				totalWeight += weight
+			}
+
+			owned := make(map[string]int)
+			for i := 0; i < keys; i++ {
+				owner, err := r.Get(fmt.Sprintf("key-%d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				owned[owner]++
+			}
+
+			for backend, weight := range tt.weights {
+				share := float64(owned[backend]) / keys
+				want := float64(weight) / float64(totalWeight)
+				if math.Abs(share-want) > 0.02 {
+					t.Errorf("share of %s = %.3f, want %.3f", backend, share, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRendezvousRemove(t *testing.T) {
+	r := NewRendezvous()
+	for _, backend := range []string{"a", "b", "c", "d"} {
+		if err := r.Add(backend, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	owners := make(map[string]string)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners[key], _ = r.Get(key)
+	}
+
+	if err := r.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the keys of the removed backend move, to their second candidate
+	for key, owner := range owners {
+		got, _ := r.Get(key)
+		if owner == "c" && got == "c" || owner != "c" && got != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, got)
+		}
+	}
+}
+
+func TestRendezvousGetCandidates(t *testing.T) {
+	r := NewRendezvous()
+	if _, err := r.GetCandidates("key"); err == nil {
+		t.Error("GetCandidates() without backends succeeded")
+	}
+	for _, backend := range []string{"a", "b", "c"} {
+		if err := r.Add(backend, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		candidates, err := r.GetCandidates(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner, _ := r.Get(key)
+		if len(candidates) != 3 || candidates[0] != owner {
+			t.Fatalf("GetCandidates(%q) = %v, want every backend starting with %s", key, candidates, owner)
+		}
+
+		// the second candidate owns the key once the owner is removed
+		if err := r.Remove(owner); err != nil {
+			t.Fatal(err)
+		}
+		if next, _ := r.Get(key); next != candidates[1] {
+			t.Errorf("owner of %q without %s = %s, want %s", key, owner, next, candidates[1])
+		}
+		if err := r.Add(owner, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/stream"
+)
+
+const (
+	// RetryOnConnectFailure retries requests which failed to connect to their backend.
+	RetryOnConnectFailure = "connect-failure"
+	// RetryOnReset retries requests whose connection failed after they were sent,
+	// such as a reset or a closed connection, before any response.
+	RetryOnReset = "reset"
+	// RetryOnOverflow retries requests rejected by the circuit breaker of their backend.
+	RetryOnOverflow = "overflow"
+	// RetryOnGatewayError retries the 502, 503 and 504 responses of backends.
+	// Other statuses are retried by listing their code, such as "500".
+	RetryOnGatewayError = "gateway-error"
+
+	DefaultMaxAttempts         = 3
+	DefaultRetryBaseInterval   = 25 * time.Millisecond
+	DefaultRetryMaxInterval    = 250 * time.Millisecond
+	DefaultRetryMaxBodyBytes   = 64 << 10
+	DefaultRetryBudgetPercent  = 20
+	DefaultMinRetryConcurrency = 3
+
+	// maxKeptBodyBytes is the maximum size of the kept body of a discarded response.
+	maxKeptBodyBytes = 64 << 10
+)
+
+var (
+	ErrUnknownRetryOn = errors.New("unknown retry-on condition")
+
+	// DefaultRetryOn are the conditions retried if none are configured.
+	DefaultRetryOn = []string{RetryOnConnectFailure, RetryOnReset, RetryOnOverflow, RetryOnGatewayError}
+
+	retries          = expvar.NewInt("l7_retries")
+	retriesExhausted = expvar.NewInt("l7_retry_budget_exhausted")
+)
+
+// RetryConfig is the retry policy of a route, which retries failed requests on
+// another backend of the pool.
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of attempts of a request, including the
+	// first one. Requests are not retried if it is one.
+	MaxAttempts int `mapstructure:"max-attempts"`
+	// RetryOn are the retried conditions, see the RetryOn constants.
+	RetryOn []string `mapstructure:"retry-on"`
+	// BaseInterval and MaxInterval bound the exponential back-off with full
+	// jitter between attempts.
+	BaseInterval time.Duration `mapstructure:"base-interval"`
+	MaxInterval  time.Duration `mapstructure:"max-interval"`
+	// RetryNonIdempotent retries requests of non-idempotent methods, such as
+	// POST, even if they may have reached their backend. They are only retried
+	// when they didn't otherwise.
+	RetryNonIdempotent bool `mapstructure:"retry-non-idempotent"`
+	// MaxBodyBytes is the maximum size of the request bodies buffered to be
+	// replayed. Requests with larger bodies or bodies of unknown length, such
+	// as streams, are only retried if their body wasn't sent, such as when
+	// their backend refused the connection.
+	MaxBodyBytes int64 `mapstructure:"max-body-bytes"`
+}
+
+// RetryBudgetConfig limits the retries of a pool to a share of its active
+// requests, so that retries don't overload a failing pool.
+type RetryBudgetConfig struct {
+	// BudgetPercent is the maximum percentage of the active requests of the
+	// pool which may be retries.
+	BudgetPercent float64 `mapstructure:"budget-percent"`
+	// MinRetryConcurrency is the number of concurrent retries always allowed.
+	MinRetryConcurrency int `mapstructure:"min-retry-concurrency"`
+}
+
+// budget returns the number of concurrent retries allowed for the number of
+// active requests of a pool.
+func (c RetryBudgetConfig) budget(active int64) int64 {
+	budget := int64(float64(active) * c.BudgetPercent / 100)
+	if budget < int64(c.MinRetryConcurrency) {
+		budget = int64(c.MinRetryConcurrency)
+	}
+
+	return budget
+}
+
+// retryingLoadBalancer retries the failed requests of a load balancer, which
+// chooses another backend than the ones the request already tried.
+type retryingLoadBalancer struct {
+	loadBalancer LoadBalancer
+	pool         *BackendPool
+
+	maxAttempts        int
+	retryOnConnect     bool
+	retryOnReset       bool
+	retryOnOverflow    bool
+	retryOnStatuses    map[int]bool
+	baseInterval       time.Duration
+	maxInterval        time.Duration
+	retryNonIdempotent bool
+	maxBodyBytes       int64
+}
+
+func newRetryingLoadBalancer(loadBalancer LoadBalancer, pool *BackendPool, config RetryConfig) (*retryingLoadBalancer, error) {
+	lb := &retryingLoadBalancer{
+		loadBalancer: loadBalancer,
+		pool:         pool,
+
+		maxAttempts:        config.MaxAttempts,
+		retryOnStatuses:    make(map[int]bool),
+		baseInterval:       config.BaseInterval,
+		maxInterval:        config.MaxInterval,
+		retryNonIdempotent: config.RetryNonIdempotent,
+		maxBodyBytes:       config.MaxBodyBytes,
+	}
+	if lb.baseInterval <= 0 {
+		lb.baseInterval = DefaultRetryBaseInterval
+	}
+	if lb.maxInterval < lb.baseInterval {
+		lb.maxInterval = DefaultRetryMaxInterval
+	}
+	if lb.maxBodyBytes <= 0 {
+		lb.maxBodyBytes = DefaultRetryMaxBodyBytes
+	}
+
+	retryOn := config.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = DefaultRetryOn
+	}
+	for _, condition := range retryOn {
+		switch condition {
+		case RetryOnConnectFailure:
+			lb.retryOnConnect = true
+		case RetryOnReset:
+			lb.retryOnReset = true
+		case RetryOnOverflow:
+			lb.retryOnOverflow = true
+		case RetryOnGatewayError:
+			lb.retryOnStatuses[http.StatusBadGateway] = true
+			lb.retryOnStatuses[http.StatusServiceUnavailable] = true
+			lb.retryOnStatuses[http.StatusGatewayTimeout] = true
+		default:
+			status, err := strconv.Atoi(condition)
+			if err != nil || status < 100 || status > 599 {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownRetryOn, condition)
+			}
+			lb.retryOnStatuses[status] = true
+		}
+	}
+
+	return lb, nil
+}
+
+// ServeProxy serves the request with the load balancer, and retries it on
+// another backend while its attempts fail with a retried condition, it has
+// attempts left, and the pool has retry budget left.
+func (lb *retryingLoadBalancer) ServeProxy(rw http.ResponseWriter, req *http.Request) {
+	// upgraded connections can't be replayed
+	if backend.IsUpgrade(req) {
+		lb.loadBalancer.ServeProxy(rw, req)
+		return
+	}
+
+	replayable := lb.bufferBody(req)
+	var body *unreadBody
+	if !replayable {
+		body = &unreadBody{body: req.Body}
+		req.Body = body
+	}
+
+	var tried []*backend.Backend
+	var failed *attemptWriter
+	release := func() {}
+	for attempt := 1; ; attempt++ {
+		record := &backend.Attempt{}
+		ctx := backend.WithAttempt(withTried(req.Context(), tried), record)
+		attemptReq := req.WithContext(ctx)
+		if replayable && req.GetBody != nil {
+			replayed, _ := req.GetBody()
+			attemptReq.Body = replayed
+		}
+
+		aw := &attemptWriter{
+			rw:     rw,
+			header: make(http.Header),
+		}
+		aw.retry = func() bool {
+			if failed != nil && record.Backend == nil {
+				// no other backend was available, keep the response of the previous attempt
+				return true
+			}
+			// a body which can't be replayed can still be sent by the next
+			// attempt if the failed one didn't send it
+			resendable := replayable || (!record.Sent() && body.unread())
+			return resendable && attempt < lb.maxAttempts && lb.shouldRetry(req, record)
+		}
+		// a retry counts against the budget until its response starts, so
+		// that retried streams don't hold it
+		aw.onCommit = release
+
+		lb.loadBalancer.ServeProxy(aw, attemptReq)
+		release()
+		if !aw.discarded {
+			aw.commit()
+			return
+		}
+		if record.Backend == nil {
+			failed.replay()
+			return
+		}
+
+		failed = aw
+		tried = append(tried, record.Backend)
+		var ok bool
+		if release, ok = lb.acquireRetry(req, record.Backend, attempt); !ok {
+			failed.replay()
+			return
+		}
+	}
+}
+
+// acquireRetry waits for the back-off of the next attempt, within the retry
+// budget of the pool and the circuit breaker of the failed backend. It returns
+// the release function of the retry.
+func (lb *retryingLoadBalancer) acquireRetry(req *http.Request, failed *backend.Backend, attempt int) (func(), bool) {
+	releaseBudget, ok := lb.pool.acquireRetry()
+	if !ok {
+		retriesExhausted.Add(1)
+		log.Printf("[LoadBalancer] Retry budget of the pool exhausted, not retrying %s", req.URL)
+		return nil, false
+	}
+
+	releaseBreaker, ok := failed.AcquireRetry()
+	if !ok {
+		releaseBudget()
+		log.Printf("[LoadBalancer] Too many retries of backend %s, not retrying %s", failed.ID, req.URL)
+		return nil, false
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			releaseBreaker()
+			releaseBudget()
+		})
+	}
+
+	// exponential back-off with full jitter
+	interval := lb.baseInterval << (attempt - 1)
+	if interval > lb.maxInterval || interval <= 0 {
+		interval = lb.maxInterval
+	}
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(interval) + 1)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-req.Context().Done():
+		release()
+		return nil, false
+	}
+
+	retries.Add(1)
+	log.Printf("[LoadBalancer] Retrying %s failed by backend %s (attempt %d)", req.URL, failed.ID, attempt+1)
+	return release, true
+}
+
+// shouldRetry returns whether the attempt failed with a retried condition.
+// Responses written by l7 itself, such as when a stream moves, are not retried.
+func (lb *retryingLoadBalancer) shouldRetry(req *http.Request, attempt *backend.Attempt) bool {
+	if attempt.Backend == nil || req.Context().Err() != nil {
+		return false
+	}
+	if attempt.Sent() && !lb.retryNonIdempotent && !isIdempotent(req.Method) {
+		return false
+	}
+
+	switch {
+	case attempt.Overflow != "":
+		return lb.retryOnOverflow
+	case attempt.Result.Err != nil:
+		if errors.Is(attempt.Result.Err, context.DeadlineExceeded) || errors.Is(attempt.Result.Err, context.Canceled) {
+			return false
+		}
+		// a backend which didn't respond in time is a gateway timeout
+		if errors.Is(attempt.Result.Err, backend.ErrResponseHeaderTimeout) {
+			return lb.retryOnStatuses[http.StatusGatewayTimeout]
+		}
+		if backend.IsConnectFailure(attempt.Result.Err) {
+			return lb.retryOnConnect
+		}
+		return lb.retryOnReset
+	}
+
+	// the status of the backend rather than the status written to the client,
+	// which is translated for gRPC clients
+	return lb.retryOnStatuses[attempt.Result.Status]
+}
+
+// bufferBody makes the body of the request replayable with GetBody, and
+// returns false if it can't be replayed. Only bodies of a known length up to
+// the maximum body size are buffered, so that streams, whose client may wait
+// for a response before ending its body, and large uploads are proxied as
+// they come, and are only retried if not sent.
+func (lb *retryingLoadBalancer) bufferBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if stream.IsStream(req) || isStreamingContentType(req.Header.Get("Content-Type")) {
+		return false
+	}
+	if req.ContentLength < 0 || req.ContentLength > lb.maxBodyBytes {
+		return false
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, req.ContentLength))
+	if err != nil {
+		// send what was read followed by the rest of the body, once
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return false
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return true
+}
+
+// unreadBody is a request body which can't be replayed, which is kept open
+// across attempts until it is read, so that the attempts which failed before
+// sending it can be retried.
+type unreadBody struct {
+	body io.ReadCloser
+	read int32
+}
+
+func (b *unreadBody) Read(p []byte) (int, error) {
+	atomic.StoreInt32(&b.read, 1)
+	return b.body.Read(p)
+}
+
+// Close closes the body once it was read. Transports close the body of the
+// requests they fail, which would otherwise end it before the next attempt.
+func (b *unreadBody) Close() error {
+	if b.unread() {
+		return nil
+	}
+
+	return b.body.Close()
+}
+
+func (b *unreadBody) unread() bool {
+	return atomic.LoadInt32(&b.read) == 0
+}
+
+// isStreamingContentType returns whether requests of the content type may
+// stream their body: gRPC, whose unary and streaming calls look alike, and
+// Connect streaming.
+func isStreamingContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/grpc") ||
+		strings.HasPrefix(contentType, "application/connect+")
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+// attemptWriter holds back the response of an attempt until its status is
+// known, and then either writes it to the client or discards it to retry the
+// request. Discarded responses are kept, up to a limit, to be replayed if the
+// retry can't be sent.
+type attemptWriter struct {
+	rw     http.ResponseWriter
+	header http.Header
+	// retry returns whether the response is discarded to retry the request,
+	// and onCommit is called when the response is written to the client.
+	retry    func() bool
+	onCommit func()
+
+	status      int
+	wroteHeader bool
+	discarded   bool
+	body        bytes.Buffer
+}
+
+func (w *attemptWriter) Header() http.Header {
+	if w.wroteHeader && !w.discarded {
+		return w.rw.Header()
+	}
+
+	return w.header
+}
+
+func (w *attemptWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	// informational responses are written to the client as they come
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		copyHeader(w.rw.Header(), w.header)
+		w.rw.WriteHeader(status)
+		return
+	}
+
+	w.status = status
+	w.wroteHeader = true
+	if w.retry() {
+		w.discarded = true
+		return
+	}
+
+	w.onCommit()
+	copyHeader(w.rw.Header(), w.header)
+	w.rw.WriteHeader(status)
+}
+
+func (w *attemptWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discarded {
+		if w.body.Len() < maxKeptBodyBytes {
+			w.body.Write(p)
+		}
+		return len(p), nil
+	}
+
+	return w.rw.Write(p)
+}
+
+// Flush implements http.Flusher for streamed responses.
+func (w *attemptWriter) Flush() {
+	if w.discarded {
+		return
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(w.rw).Flush()
+}
+
+// Unwrap returns the response writer of the client for http.ResponseController.
+func (w *attemptWriter) Unwrap() http.ResponseWriter {
+	return w.rw
+}
+
+// commit writes the headers of an attempt which wrote no response.
+func (w *attemptWriter) commit() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// replay writes the discarded response to the client.
+func (w *attemptWriter) replay() {
+	copyHeader(w.rw.Header(), w.header)
+	w.rw.WriteHeader(w.status)
+	_, _ = w.rw.Write(w.body.Bytes())
+}
+
+func copyHeader(dst, src http.Header) {
+	for key, values := range src {
+		dst[key] = values
+	}
+}
+
+// withTried returns a context excluding the backends from the next attempt of its request.
+func withTried(ctx context.Context, tried []*backend.Backend) context.Context {
+	if len(tried) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, triedKey{}, tried)
+}
+
+// IsAvailable returns whether the load balancer may send the request to the
+// backend, which is available and wasn't tried by a previous attempt of the request.
+func IsAvailable(req *http.Request, b *backend.Backend) bool {
+	return b.IsAvailable() && !Tried(req, b)
+}
+
+// Tried returns whether a previous attempt of the request was sent to the backend.
+func Tried(req *http.Request, b *backend.Backend) bool {
+	tried, _ := req.Context().Value(triedKey{}).([]*backend.Backend)
+	for _, t := range tried {
+		if t == b {
+			return true
+		}
+	}
+
+	return false
+}
+
+type triedKey struct{}
+
+// acquireRetry returns whether the pool has retry budget left, and if so, the
+// release function of the retry.
+func (p *BackendPool) acquireRetry() (release func(), ok bool) {
+	var active int64
+	for _, b := range p.Registry.GetBackends() {
+		active += b.ActiveRequests()
+	}
+
+	if inFlight := atomic.AddInt64(&p.retries, 1); inFlight > p.retryBudget.budget(active) {
+		atomic.AddInt64(&p.retries, -1)
+		return nil, false
+	}
+
+	return func() {
+		atomic.AddInt64(&p.retries, -1)
+	}, true
+}
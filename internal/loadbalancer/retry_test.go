@@ -0,0 +1,503 @@
+package loadbalancer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/grpc"
+	"github.com/krapie/l7/internal/stream"
+)
+
+func TestShouldRetry(t *testing.T) {
+	b, err := backend.NewDefaultBackend("backend", "http://127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connectFailure := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+
+	tests := []struct {
+		name               string
+		retryOn            []string
+		retryNonIdempotent bool
+		method             string
+		attempt            backend.Attempt
+		want               bool
+	}{
+		{
+			name:    "no backend",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Result: backend.Result{Status: http.StatusServiceUnavailable}},
+			want:    false,
+		},
+		{
+			name:    "success",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusOK}},
+			want:    false,
+		},
+		{
+			name:    "gateway error",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusBadGateway}},
+			want:    true,
+		},
+		{
+			name:    "internal error not retried by default",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusInternalServerError}},
+			want:    false,
+		},
+		{
+			name:    "listed status",
+			retryOn: []string{"500"},
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusInternalServerError}},
+			want:    true,
+		},
+		{
+			name:    "unlisted gateway error",
+			retryOn: []string{"500"},
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusBadGateway}},
+			want:    false,
+		},
+		{
+			name:    "connect failure",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Err: connectFailure}},
+			want:    true,
+		},
+		{
+			name:    "connect failure not listed",
+			retryOn: []string{RetryOnReset},
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Err: connectFailure}},
+			want:    false,
+		},
+		{
+			name:    "reset",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Err: reset}},
+			want:    true,
+		},
+		{
+			name:    "response header timeout is a gateway timeout",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Err: backend.ErrResponseHeaderTimeout}},
+			want:    true,
+		},
+		{
+			name:    "response header timeout without gateway errors",
+			retryOn: []string{RetryOnConnectFailure, RetryOnReset},
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Err: backend.ErrResponseHeaderTimeout}},
+			want:    false,
+		},
+		{
+			name:    "canceled",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Err: context.Canceled}},
+			want:    false,
+		},
+		{
+			name:    "overflow",
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Overflow: backend.OverflowMaxRequests},
+			want:    true,
+		},
+		{
+			name:    "overflow not listed",
+			retryOn: []string{RetryOnGatewayError},
+			method:  http.MethodGet,
+			attempt: backend.Attempt{Backend: b, Overflow: backend.OverflowMaxRequests},
+			want:    false,
+		},
+		{
+			name:    "non-idempotent sent",
+			method:  http.MethodPost,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusServiceUnavailable}},
+			want:    false,
+		},
+		{
+			name:    "non-idempotent reset",
+			method:  http.MethodPost,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Err: reset}},
+			want:    false,
+		},
+		{
+			name:    "non-idempotent connect failure",
+			method:  http.MethodPost,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Err: connectFailure}},
+			want:    true,
+		},
+		{
+			name:    "non-idempotent overflow",
+			method:  http.MethodPost,
+			attempt: backend.Attempt{Backend: b, Overflow: backend.OverflowMaxPendingRequests},
+			want:    true,
+		},
+		{
+			name:               "non-idempotent sent with retry-non-idempotent",
+			retryNonIdempotent: true,
+			method:             http.MethodPost,
+			attempt:            backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusServiceUnavailable}},
+			want:               true,
+		},
+		{
+			name:    "idempotent put",
+			method:  http.MethodPut,
+			attempt: backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusServiceUnavailable}},
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb, err := newRetryingLoadBalancer(nil, nil, RetryConfig{
+				MaxAttempts:        DefaultMaxAttempts,
+				RetryOn:            tt.retryOn,
+				RetryNonIdempotent: tt.retryNonIdempotent,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if got := lb.shouldRetry(req, &tt.attempt); got != tt.want {
+				t.Errorf("shouldRetry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShouldRetryCanceledRequest(t *testing.T) {
+	b, err := backend.NewDefaultBackend("backend", "http://127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := newRetryingLoadBalancer(nil, nil, RetryConfig{MaxAttempts: DefaultMaxAttempts})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	attempt := &backend.Attempt{Backend: b, Result: backend.Result{Status: http.StatusServiceUnavailable}}
+	if lb.shouldRetry(req, attempt) {
+		t.Error("shouldRetry() = true for a canceled request")
+	}
+}
+
+func TestNewRetryingLoadBalancerRetryOn(t *testing.T) {
+	tests := []struct {
+		retryOn []string
+		wantErr bool
+	}{
+		{retryOn: nil},
+		{retryOn: []string{RetryOnConnectFailure, RetryOnReset, RetryOnOverflow, RetryOnGatewayError}},
+		{retryOn: []string{"500", "429"}},
+		{retryOn: []string{"5xx"}, wantErr: true},
+		{retryOn: []string{"99"}, wantErr: true},
+		{retryOn: []string{"600"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.retryOn, ","), func(t *testing.T) {
+			_, err := newRetryingLoadBalancer(nil, nil, RetryConfig{RetryOn: tt.retryOn})
+			if gotErr := errors.Is(err, ErrUnknownRetryOn); gotErr != tt.wantErr {
+				t.Errorf("newRetryingLoadBalancer() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodOptions: true,
+		http.MethodTrace:   true,
+		http.MethodPut:     true,
+		http.MethodDelete:  true,
+		http.MethodPost:    false,
+		http.MethodPatch:   false,
+		http.MethodConnect: false,
+	}
+
+	for method, want := range tests {
+		if got := isIdempotent(method); got != want {
+			t.Errorf("isIdempotent(%s) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RetryBudgetConfig
+		active int64
+		want   int64
+	}{
+		{name: "idle pool", config: RetryBudgetConfig{BudgetPercent: 20, MinRetryConcurrency: 3}, active: 0, want: 3},
+		{name: "below min concurrency", config: RetryBudgetConfig{BudgetPercent: 20, MinRetryConcurrency: 3}, active: 10, want: 3},
+		{name: "percent of active", config: RetryBudgetConfig{BudgetPercent: 20, MinRetryConcurrency: 3}, active: 100, want: 20},
+		{name: "rounded down", config: RetryBudgetConfig{BudgetPercent: 20, MinRetryConcurrency: 3}, active: 104, want: 20},
+		{name: "no min concurrency", config: RetryBudgetConfig{BudgetPercent: 50}, active: 1, want: 0},
+		{name: "whole pool", config: RetryBudgetConfig{BudgetPercent: 100, MinRetryConcurrency: 1}, active: 7, want: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.budget(tt.active); got != tt.want {
+				t.Errorf("budget(%d) = %d, want %d", tt.active, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcquireRetry(t *testing.T) {
+	pool := &BackendPool{
+		Registry:    registry.NewRegistry(),
+		retryBudget: RetryBudgetConfig{BudgetPercent: DefaultRetryBudgetPercent, MinRetryConcurrency: 2},
+	}
+
+	release1, ok := pool.acquireRetry()
+	if !ok {
+		t.Fatal("first retry rejected")
+	}
+	release2, ok := pool.acquireRetry()
+	if !ok {
+		t.Fatal("second retry rejected")
+	}
+	if _, ok = pool.acquireRetry(); ok {
+		t.Fatal("retry over the budget allowed")
+	}
+
+	release1()
+	release3, ok := pool.acquireRetry()
+	if !ok {
+		t.Fatal("retry rejected after a release")
+	}
+	release2()
+	release3()
+}
+
+func TestBufferBody(t *testing.T) {
+	const maxBodyBytes = 16
+
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		contentType   string
+		stream        bool
+		want          bool
+	}{
+		{name: "no body", want: true},
+		{name: "known length", body: "hello", contentLength: 5, want: true},
+		{name: "at the limit", body: strings.Repeat("a", maxBodyBytes), contentLength: maxBodyBytes, want: true},
+		{name: "over the limit", body: strings.Repeat("a", maxBodyBytes+1), contentLength: maxBodyBytes + 1, want: false},
+		{name: "unknown length", body: "hello", contentLength: -1, want: false},
+		{name: "grpc", body: "hello", contentLength: 5, contentType: "application/grpc+proto", want: false},
+		{name: "grpc-web", body: "hello", contentLength: 5, contentType: "application/grpc-web+proto", want: false},
+		{name: "connect streaming", body: "hello", contentLength: 5, contentType: "application/connect+json", want: false},
+		{name: "connect unary", body: "hello", contentLength: 5, contentType: "application/json", want: true},
+		{name: "stream", body: "hello", contentLength: 5, stream: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb, err := newRetryingLoadBalancer(nil, nil, RetryConfig{MaxBodyBytes: maxBodyBytes})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.body != "" {
+				// a reader httptest can't measure, so that the content length is the one of the test
+				req.Body = io.NopCloser(struct{ io.Reader }{strings.NewReader(tt.body)})
+			}
+			req.ContentLength = tt.contentLength
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.stream {
+				req = req.WithContext(stream.WithStream(req.Context()))
+			}
+
+			if got := lb.bufferBody(req); got != tt.want {
+				t.Errorf("bufferBody() = %v, want %v", got, tt.want)
+			}
+
+			// the body is proxied whole whether or not it was buffered
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+
+			if tt.want && tt.body != "" {
+				replayed, err := req.GetBody()
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ = io.ReadAll(replayed)
+				if !bytes.Equal(body, []byte(tt.body)) {
+					t.Errorf("replayed body = %q, want %q", body, tt.body)
+				}
+			}
+		})
+	}
+}
+
+// firstAvailableLB serves requests to the first available backend of its pool.
+type firstAvailableLB struct {
+	pool *BackendPool
+}
+
+func (lb *firstAvailableLB) ServeProxy(rw http.ResponseWriter, req *http.Request) {
+	for _, b := range lb.pool.Registry.GetBackends() {
+		if IsAvailable(req, b) {
+			lb.pool.Serve(rw, req, b)
+			return
+		}
+	}
+
+	rw.WriteHeader(http.StatusServiceUnavailable)
+}
+
+func TestRetryingLoadBalancerServeProxy(t *testing.T) {
+	var okBodies []string
+	ok := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		okBodies = append(okBodies, string(body))
+	}))
+	defer ok.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	// a listener which is closed refuses connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + listener.Addr().String()
+	_ = listener.Close()
+
+	tests := []struct {
+		name        string
+		first       string
+		method      string
+		body        string
+		contentType string
+		budget      RetryBudgetConfig
+		wantStatus  int
+		// wantGRPCStatus is the status of gRPC requests, whose responses
+		// are translated to gRPC
+		wantGRPCStatus string
+		wantOK         []string
+	}{
+		{
+			name:       "retried on another backend",
+			first:      failing.URL,
+			method:     http.MethodPut,
+			body:       "hello",
+			budget:     RetryBudgetConfig{BudgetPercent: DefaultRetryBudgetPercent, MinRetryConcurrency: 1},
+			wantStatus: http.StatusOK,
+			wantOK:     []string{"hello"},
+		},
+		{
+			name:       "retry budget exhausted",
+			first:      failing.URL,
+			method:     http.MethodPut,
+			body:       "hello",
+			budget:     RetryBudgetConfig{BudgetPercent: DefaultRetryBudgetPercent},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "unbuffered body not sent",
+			first:          refused,
+			method:         http.MethodPost,
+			body:           "hello",
+			contentType:    "application/grpc",
+			budget:         RetryBudgetConfig{BudgetPercent: DefaultRetryBudgetPercent, MinRetryConcurrency: 1},
+			wantStatus:     http.StatusOK,
+			wantGRPCStatus: "0",
+			wantOK:         []string{"hello"},
+		},
+		{
+			name:           "unbuffered body sent",
+			first:          failing.URL,
+			method:         http.MethodPost,
+			body:           "hello",
+			contentType:    "application/grpc",
+			budget:         RetryBudgetConfig{BudgetPercent: DefaultRetryBudgetPercent, MinRetryConcurrency: 1},
+			wantStatus:     http.StatusOK,
+			wantGRPCStatus: strconv.Itoa(int(grpc.Unavailable)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			okBodies = nil
+
+			pool := &BackendPool{
+				Registry:    registry.NewRegistry(),
+				Streams:     stream.NewRegistry(),
+				retryBudget: tt.budget,
+			}
+			if err := pool.Registry.AddBackend("first", tt.first, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := pool.Registry.AddBackend("ok", ok.URL, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			lb, err := newRetryingLoadBalancer(&firstAvailableLB{pool: pool}, pool, RetryConfig{
+				MaxAttempts:  DefaultMaxAttempts,
+				BaseInterval: time.Millisecond,
+				MaxInterval:  time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			lb.ServeProxy(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get(grpc.StatusHeader); got != tt.wantGRPCStatus {
+				t.Errorf("%s = %q, want %q", grpc.StatusHeader, got, tt.wantGRPCStatus)
+			}
+			if strings.Join(okBodies, ",") != strings.Join(tt.wantOK, ",") {
+				t.Errorf("bodies received by the other backend = %q, want %q", okBodies, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package ring_hash
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// DefaultReplicas is the number of points of a backend of weight 1 on the
+	// ring, which is the number of points per server of libketama.
+	DefaultReplicas = 160
+)
+
+type point struct {
+	hash    uint32
+	backend string
+}
+
+// Ring is a ketama consistent hash ring. Each backend owns replicas×weight
+// points on the ring, and a key is owned by the backend of the first point
+// clockwise from the hash of the key. Adding or removing a backend only moves
+// the keys of its own points, and the ring is much smaller than a maglev table.
+type Ring struct {
+	replicas int
+	weights  map[string]int
+	points   []point
+	lock     sync.RWMutex
+}
+
+func NewRing(replicas int) (*Ring, error) {
+	if replicas <= 0 {
+		return nil, errors.New("[ring-hash] replicas must be positive")
+	}
+
+	return &Ring{
+		replicas: replicas,
+		weights:  make(map[string]int),
+	}, nil
+}
+
+// Add : Return nil if add success, otherwise return error
+func (r *Ring) Add(backend string, weight int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.weights[backend]; ok {
+		return errors.New("[ring-hash] exist already")
+	}
+	if weight <= 0 {
+		return errors.New("[ring-hash] weight must be positive")
+	}
+
+	r.weights[backend] = weight
+	r.build()
+	return nil
+}
+
+// Remove : Return nil if remove success, otherwise return error
+func (r *Ring) Remove(backend string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.weights[backend]; !ok {
+		return errors.New("[ring-hash] node not found")
+	}
+
+	delete(r.weights, backend)
+	r.build()
+	return nil
+}
+
+// SetWeight : Rebuild the ring with the new weight of the backend
+func (r *Ring) SetWeight(backend string, weight int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.weights[backend]; !ok {
+		return errors.New("[ring-hash] node not found")
+	}
+	if weight <= 0 {
+		return errors.New("[ring-hash] weight must be positive")
+	}
+
+	r.weights[backend] = weight
+	r.build()
+	return nil
+}
+
+// Get : Get node name by object string.
+func (r *Ring) Get(obj string) (string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.points) == 0 {
+		return "", errors.New("[ring-hash] empty")
+	}
+
+	return r.points[r.search(obj)].backend, nil
+}
+
+// GetCandidates : Get distinct node names by object string, in the order they
+// appear clockwise on the ring from the hash of the object.
+func (r *Ring) GetCandidates(obj string) ([]string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if len(r.points) == 0 {
+		return nil, errors.New("[ring-hash] empty")
+	}
+
+	start := r.search(obj)
+	seen := make(map[string]bool, len(r.weights))
+	var candidates []string
+	for i := 0; i < len(r.points) && len(candidates) < len(r.weights); i++ {
+		p := r.points[(start+i)%len(r.points)]
+		if seen[p.backend] {
+			continue
+		}
+
+		seen[p.backend] = true
+		candidates = append(candidates, p.backend)
+	}
+
+	return candidates, nil
+}
+
+// search returns the index of the first point clockwise from the hash of the object.
+func (r *Ring) search(obj string) int {
+	digest := md5.Sum([]byte(obj))
+	hash := binary.LittleEndian.Uint32(digest[0:4])
+
+	index := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if index == len(r.points) {
+		index = 0
+	}
+
+	return index
+}
+
+// build places the points of every backend on the ring like libketama: each
+// md5 digest of "<backend>-<i>" yields four points.
+func (r *Ring) build() {
+	var points []point
+	for backend, weight := range r.weights {
+		for i := 0; i < (r.replicas*weight+3)/4; i++ {
+			digest := md5.Sum([]byte(backend + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				points = append(points, point{
+					hash:    binary.LittleEndian.Uint32(digest[j*4 : j*4+4]),
+					backend: backend,
+				})
+			}
+		}
+	}
+
+	// break hash collisions by backend name so that the ring doesn't depend on map order
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].backend < points[j].backend
+		}
+		return points[i].hash < points[j].hash
+	})
+	r.points = points
+}
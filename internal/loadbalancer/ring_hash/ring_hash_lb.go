@@ -0,0 +1,21 @@
+package ring_hash
+
+import (
+	"github.com/krapie/l7/internal/loadbalancer"
+	"github.com/krapie/l7/internal/loadbalancer/maglev"
+)
+
+const (
+	Algorithm = "ring-hash"
+)
+
+func init() {
+	loadbalancer.RegisterFactory(Algorithm, func(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+		ring, err := NewRing(DefaultReplicas)
+		if err != nil {
+			return nil, err
+		}
+
+		return maglev.NewLBWithTable(pool, config, ring)
+	})
+}
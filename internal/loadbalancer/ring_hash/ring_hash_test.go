@@ -0,0 +1,118 @@
+package ring_hash
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+const keys = 20000
+
+func TestRingDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+	}{
+		{name: "equal weights", weights: map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}},
+		{name: "double weight", weights: map[string]int{"a": 1, "b": 2}},
+		{name: "mixed weights", weights: map[string]int{"a": 1, "b": 2, "c": 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRing(DefaultReplicas)
+			if err != nil {
+				t.Fatal(err)
+			}
+			totalWeight := 0
+			for backend, weight := range tt.weights {
+				if err := r.Add(backend, weight); err != nil {
+					t.Fatal(err)
+				}
+				totalWeight += weight
+			}
+
+			owned := make(map[string]int)
+			for i := 0; i < keys; i++ {
+				owner, err := r.Get(fmt.Sprintf("key-%d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				owned[owner]++
+			}
+
+			// a ring of 160 points per weight spreads keys within a few percent
+			for backend, weight := range tt.weights {
+				share := float64(owned[backend]) / keys
+				want := float64(weight) / float64(totalWeight)
+				if math.Abs(share-want) > 0.05 {
+					t.Errorf("share of %s = %.3f, want %.3f", backend, share, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRingRemove(t *testing.T) {
+	r, err := NewRing(DefaultReplicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, backend := range []string{"a", "b", "c", "d"} {
+		if err := r.Add(backend, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	owners := make(map[string]string)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners[key], _ = r.Get(key)
+	}
+
+	if err := r.Remove("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the keys of the removed backend move
+	for key, owner := range owners {
+		got, _ := r.Get(key)
+		if owner == "c" && got == "c" || owner != "c" && got != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, got)
+		}
+	}
+
+	if err := r.Remove("c"); err == nil {
+		t.Error("Remove() of a removed backend succeeded")
+	}
+}
+
+func TestRingGetCandidates(t *testing.T) {
+	r, err := NewRing(DefaultReplicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetCandidates("key"); err == nil {
+		t.Error("GetCandidates() of an empty ring succeeded")
+	}
+	for _, backend := range []string{"a", "b", "c"} {
+		if err := r.Add(backend, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		candidates, err := r.GetCandidates(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner, _ := r.Get(key)
+		if len(candidates) != 3 || candidates[0] != owner {
+			t.Fatalf("GetCandidates(%q) = %v, want every backend starting with %s", key, candidates, owner)
+		}
+		if candidates[1] == candidates[2] || candidates[0] == candidates[1] || candidates[0] == candidates[2] {
+			t.Errorf("GetCandidates(%q) = %v, want distinct backends", key, candidates)
+		}
+	}
+}
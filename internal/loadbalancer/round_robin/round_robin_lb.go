@@ -3,85 +3,71 @@ package round_robin
 import (
 	"log"
 	"net/http"
+	"sync"
 	"sync/atomic"
 
 	"github.com/krapie/l7/internal/backend"
-	"github.com/krapie/l7/internal/backend/health"
-	"github.com/krapie/l7/internal/backend/register"
-	"github.com/krapie/l7/internal/backend/register/docker"
-	"github.com/krapie/l7/internal/backend/register/k8s"
 	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/grpc"
 	"github.com/krapie/l7/internal/loadbalancer"
 )
 
-type Config struct {
-	ServiceDiscoveryMode string
-	TargetFilter         string
+const (
+	Algorithm         = "round-robin"
+	WeightedAlgorithm = "weighted-round-robin"
+)
+
+func init() {
+	loadbalancer.RegisterFactory(Algorithm, func(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+		return NewLB(pool, false)
+	})
+	loadbalancer.RegisterFactory(WeightedAlgorithm, func(pool *loadbalancer.BackendPool, config *loadbalancer.Config) (loadbalancer.LoadBalancer, error) {
+		return NewLB(pool, true)
+	})
 }
 
 type RoundRobinLB struct {
+	pool            *loadbalancer.BackendPool
 	backendRegistry *registry.BackendRegistry
-	backendRegister register.Register
-
-	healthChecker *health.Checker
 
 	index int64
-}
-
-func NewLB(config *Config) (*RoundRobinLB, error) {
-	backendRegistry := registry.NewRegistry()
-
-	var backendRegister register.Register
-	var err error
-	if config.ServiceDiscoveryMode == loadbalancer.DiscoveryModeK8s {
-		backendRegister, err = k8s.NewRegister()
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		backendRegister, err = docker.NewRegister()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	backendRegister.SetTargetFilter(config.TargetFilter)
-	backendRegister.SetRegistry(backendRegistry)
-	err = backendRegister.Initialize()
-	if err != nil {
-		return nil, err
-	}
-
-	backendRegister.Observe()
-	log.Printf("[LoadBalancer] Running backend register")
 
-	healthChecker := health.NewHealthChecker(backendRegistry, backendRegister, 2)
-	healthChecker.Run()
-	log.Printf("[LoadBalancer] Running health check")
+	// weighted enables smooth weighted round-robin, which spreads the turns
+	// of heavier backends evenly instead of sending them in bursts.
+	weighted       bool
+	mutex          sync.Mutex
+	currentWeights map[string]int
+}
 
+func NewLB(pool *loadbalancer.BackendPool, weighted bool) (*RoundRobinLB, error) {
 	return &RoundRobinLB{
-		backendRegistry: backendRegistry,
-		backendRegister: backendRegister,
-
-		healthChecker: healthChecker,
+		pool:            pool,
+		backendRegistry: pool.Registry,
 
 		index: 0,
+
+		weighted:       weighted,
+		currentWeights: make(map[string]int),
 	}, nil
 }
 
 // ServeProxy serves the request to the next backend in the list
 // keep in mind that this function and its sub functions need to be thread safe
 func (lb *RoundRobinLB) ServeProxy(rw http.ResponseWriter, req *http.Request) {
-	if b := lb.getNextBackend(); b != nil {
+	if b := lb.getNextBackend(req); b != nil {
 		log.Printf("[LoadBalancer] Serving request to backend %s", b.Addr.String())
-		b.Serve(rw, req)
+		lb.pool.Serve(rw, req, b)
 		return
 	}
 
-	http.Error(rw, "No backends available", http.StatusServiceUnavailable)
+	grpc.Error(rw, req, "No backends available", http.StatusServiceUnavailable)
 }
 
-func (lb *RoundRobinLB) getNextBackend() *backend.Backend {
+func (lb *RoundRobinLB) getNextBackend(req *http.Request) *backend.Backend {
+	if lb.weighted {
+		return lb.getNextWeightedBackend(req)
+	}
+
 	for i := 0; i < lb.backendRegistry.Len(); i++ {
 		index := lb.getNextIndex()
 
@@ -90,7 +76,7 @@ func (lb *RoundRobinLB) getNextBackend() *backend.Backend {
 			return nil
 		}
 
-		if b.IsAlive() {
+		if loadbalancer.IsAvailable(req, b) {
 			return b
 		}
 	}
@@ -104,3 +90,47 @@ func (lb *RoundRobinLB) getNextIndex() int64 {
 
 	return index
 }
+
+// getNextWeightedBackend picks the next backend with the smooth weighted
+// round-robin algorithm of nginx: every alive backend's current weight grows by
+// its weight, the backend with the highest current weight is picked, and the
+// picked backend's current weight is lowered by the total weight. Backends
+// which failed a previous attempt of the request are not picked.
+func (lb *RoundRobinLB) getNextWeightedBackend(req *http.Request) *backend.Backend {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	var chosen *backend.Backend
+	total := 0
+	alive := make(map[string]bool)
+	for _, b := range lb.backendRegistry.GetBackends() {
+		if !b.IsAvailable() {
+			continue
+		}
+		alive[b.ID] = true
+
+		weight := b.GetWeight()
+		total += weight
+		lb.currentWeights[b.ID] += weight
+		if loadbalancer.Tried(req, b) {
+			continue
+		}
+		if chosen == nil || lb.currentWeights[b.ID] > lb.currentWeights[chosen.ID] {
+			chosen = b
+		}
+	}
+
+	// forget backends which are removed or down, so they restart from zero when they come back
+	for id := range lb.currentWeights {
+		if !alive[id] {
+			delete(lb.currentWeights, id)
+		}
+	}
+
+	if chosen == nil {
+		return nil
+	}
+
+	lb.currentWeights[chosen.ID] -= total
+	return chosen
+}
@@ -0,0 +1,84 @@
+package round_robin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/krapie/l7/internal/backend/registry"
+	"github.com/krapie/l7/internal/loadbalancer"
+)
+
+func TestGetNextBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		weighted bool
+		weights  []int
+		// want is the sequence of picked backends over two rounds
+		want string
+	}{
+		{name: "round-robin", weights: []int{1, 1, 1}, want: "bcabca"},
+		{name: "round-robin ignores weights", weights: []int{3, 1}, want: "baba"},
+		{name: "weighted equal weights", weighted: true, weights: []int{1, 1, 1}, want: "abcabc"},
+		{name: "weighted", weighted: true, weights: []int{3, 1}, want: "aabaaaba"},
+		{name: "smooth weighted", weighted: true, weights: []int{5, 1, 1}, want: "aabacaaaabacaa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &loadbalancer.BackendPool{Registry: registry.NewRegistry()}
+			for i, weight := range tt.weights {
+				id := string(rune('a' + i))
+				if err := pool.Registry.AddBackend(id, fmt.Sprintf("http://%s:8080", id), weight); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lb, err := NewLB(pool, tt.weighted)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			var got string
+			for i := 0; i < len(tt.want); i++ {
+				got += lb.getNextBackend(req).ID
+			}
+			if got != tt.want {
+				t.Errorf("picked %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetNextBackendSkipsUnavailable(t *testing.T) {
+	for _, weighted := range []bool{false, true} {
+		pool := &loadbalancer.BackendPool{Registry: registry.NewRegistry()}
+		for _, id := range []string{"a", "b"} {
+			if err := pool.Registry.AddBackend(id, "http://"+id+":8080", 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		a, _ := pool.Registry.GetBackendByID("a")
+		a.SetAlive(false)
+
+		lb, err := NewLB(pool, weighted)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for i := 0; i < 4; i++ {
+			if b := lb.getNextBackend(req); b == nil || b.ID != "b" {
+				t.Fatalf("weighted %v: picked %v, want b", weighted, b)
+			}
+		}
+
+		b, _ := pool.Registry.GetBackendByID("b")
+		b.SetAlive(false)
+		if got := lb.getNextBackend(req); got != nil {
+			t.Errorf("weighted %v: picked %s without available backends", weighted, got.ID)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+	"github.com/krapie/l7/internal/grpc"
+	"github.com/krapie/l7/internal/loadbalancer"
+	"github.com/krapie/l7/internal/stream"
+)
+
+const (
+	// allPaths marks every request of a route as a stream.
+	allPaths = "*"
+)
+
+var (
+	ErrInvalidMatch = errors.New("route must match exactly one of prefix, exact or regex")
+)
+
+// MatchConfig is the configuration of the requests matched by a route. A
+// request matches if its path matches the prefix, exact path or regular
+// expression, and its method and headers match if they are configured.
+type MatchConfig struct {
+	Prefix string `mapstructure:"prefix"`
+	Exact  string `mapstructure:"exact"`
+	Regex  string `mapstructure:"regex"`
+
+	// Methods are the allowed methods, any method is allowed if empty.
+	Methods []string `mapstructure:"methods"`
+	// Headers are the required headers. An empty value only requires the
+	// header to be present.
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// RouteConfig is the configuration of a route, which sends the matched requests
+// to the named backend pool with its own load balancing policy.
+type RouteConfig struct {
+	Name  string      `mapstructure:"name"`
+	Match MatchConfig `mapstructure:"match"`
+	Pool  string      `mapstructure:"pool"`
+	// IdleTimeout closes upgraded connections, such as WebSockets, without
+	// traffic for this long. It is 5 minutes if zero.
+	IdleTimeout time.Duration `mapstructure:"idle-timeout"`
+	// StreamPaths are the exact paths of the long-lived streams of the route,
+	// such as watch RPCs, or "*" for every request of the route. Streams are
+	// tracked per backend and moved when their backend is removed or rebalanced.
+	// Upgrade requests are always streams.
+	StreamPaths []string `mapstructure:"stream-paths"`
+
+	// ConnectTimeout is the timeout of connecting to a backend, and
+	// ResponseHeaderTimeout the timeout of a backend responding with headers.
+	// They are unlimited if zero, except for the 30s dial timeout of backends.
+	ConnectTimeout        time.Duration `mapstructure:"connect-timeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response-header-timeout"`
+	// Timeout is the maximum duration of the requests of the route, including
+	// retries, unlimited if zero. Upgraded connections are not limited.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// StreamIdleTimeout ends the streams of the route without traffic for this
+	// long, instead of limiting their total duration with Timeout.
+	StreamIdleTimeout time.Duration `mapstructure:"stream-idle-timeout"`
+
+	loadbalancer.Config `mapstructure:",squash"`
+}
+
+// Route sends requests matching its conditions to its load balancer.
+type Route struct {
+	Name string
+	Pool string
+
+	prefix  string
+	exact   string
+	regex   *regexp.Regexp
+	methods []string
+	headers map[string]string
+
+	idleTimeout time.Duration
+	streamPaths map[string]bool
+
+	backendTimeouts   backend.Timeouts
+	timeout           time.Duration
+	streamIdleTimeout time.Duration
+
+	loadBalancer loadbalancer.LoadBalancer
+}
+
+func NewRoute(config *RouteConfig, loadBalancer loadbalancer.LoadBalancer) (*Route, error) {
+	match := config.Match
+
+	count := 0
+	for _, path := range []string{match.Prefix, match.Exact, match.Regex} {
+		if path != "" {
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMatch, config.Name)
+	}
+
+	var regex *regexp.Regexp
+	if match.Regex != "" {
+		var err error
+		regex, err = regexp.Compile(match.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", config.Name, err)
+		}
+	}
+
+	var methods []string
+	for _, method := range match.Methods {
+		methods = append(methods, strings.ToUpper(method))
+	}
+
+	streamPaths := make(map[string]bool)
+	for _, path := range config.StreamPaths {
+		streamPaths[path] = true
+	}
+
+	return &Route{
+		Name: config.Name,
+		Pool: config.Pool,
+
+		prefix:  match.Prefix,
+		exact:   match.Exact,
+		regex:   regex,
+		methods: methods,
+		headers: match.Headers,
+
+		idleTimeout: config.IdleTimeout,
+		streamPaths: streamPaths,
+
+		backendTimeouts: backend.Timeouts{
+			Connect:        config.ConnectTimeout,
+			ResponseHeader: config.ResponseHeaderTimeout,
+		},
+		timeout:           config.Timeout,
+		streamIdleTimeout: config.StreamIdleTimeout,
+
+		loadBalancer: loadBalancer,
+	}, nil
+}
+
+// Matches returns whether the request matches the route.
+func (r *Route) Matches(req *http.Request) bool {
+	path := req.URL.Path
+	switch {
+	case r.prefix != "" && !strings.HasPrefix(path, r.prefix):
+		return false
+	case r.exact != "" && path != r.exact:
+		return false
+	case r.regex != nil && !r.regex.MatchString(path):
+		return false
+	}
+
+	if len(r.methods) > 0 {
+		allowed := false
+		for _, method := range r.methods {
+			if req.Method == method {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	for name, value := range r.headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 {
+			return false
+		}
+		if value != "" && !contains(values, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsStream returns whether the request is one of the configured streams of the route.
+func (r *Route) IsStream(req *http.Request) bool {
+	return r.streamPaths[allPaths] || r.streamPaths[req.URL.Path]
+}
+
+// Router sends each request to the first route matching it, in the configured order.
+type Router struct {
+	routes []*Route
+}
+
+func NewRouter(routes []*Route) *Router {
+	return &Router{
+		routes: routes,
+	}
+}
+
+// Route returns the first route matching the request.
+func (r *Router) Route(req *http.Request) (*Route, bool) {
+	for _, route := range r.routes {
+		if route.Matches(req) {
+			return route, true
+		}
+	}
+
+	return nil, false
+}
+
+func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	route, ok := r.Route(req)
+	if !ok {
+		grpc.Error(rw, req, "[Router] Route not found", http.StatusNotFound)
+		return
+	}
+
+	if route.idleTimeout > 0 {
+		req = req.WithContext(backend.WithIdleTimeout(req.Context(), route.idleTimeout))
+	}
+	if route.backendTimeouts != (backend.Timeouts{}) {
+		req = req.WithContext(backend.WithTimeouts(req.Context(), route.backendTimeouts))
+	}
+
+	isStream := route.IsStream(req)
+	if isStream {
+		req = req.WithContext(stream.WithStream(req.Context()))
+	}
+
+	// upgraded connections are limited by their idle timeout, and streams by
+	// their idle timeout if set
+	switch {
+	case backend.IsUpgrade(req):
+	case isStream && route.streamIdleTimeout > 0:
+		var stop func()
+		rw, req, stop = withStreamIdleTimeout(rw, req, route.streamIdleTimeout)
+		defer stop()
+	case route.timeout > 0:
+		ctx, cancel := context.WithTimeoutCause(req.Context(), route.timeout, backend.ErrRequestTimeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	route.loadBalancer.ServeProxy(rw, req)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,160 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/krapie/l7/internal/stream"
+)
+
+func TestRouteMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   MatchConfig
+		method  string
+		path    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "prefix", match: MatchConfig{Prefix: "/yorkie.v1.YorkieService/"}, path: "/yorkie.v1.YorkieService/PushPullChanges", want: true},
+		{name: "prefix mismatch", match: MatchConfig{Prefix: "/yorkie.v1.YorkieService/"}, path: "/whoami"},
+		{name: "exact", match: MatchConfig{Exact: "/healthz"}, path: "/healthz", want: true},
+		{name: "exact with suffix", match: MatchConfig{Exact: "/healthz"}, path: "/healthz/ready"},
+		{name: "regex", match: MatchConfig{Regex: "^/whoami(/.*)?$"}, path: "/whoami/me", want: true},
+		{name: "regex mismatch", match: MatchConfig{Regex: "^/whoami(/.*)?$"}, path: "/whoamix"},
+		{name: "method", match: MatchConfig{Prefix: "/", Methods: []string{"post"}}, method: http.MethodPost, path: "/", want: true},
+		{name: "method mismatch", match: MatchConfig{Prefix: "/", Methods: []string{"POST"}}, method: http.MethodGet, path: "/"},
+		{
+			name:    "header present",
+			match:   MatchConfig{Prefix: "/", Headers: map[string]string{"X-Debug": ""}},
+			path:    "/",
+			headers: map[string]string{"X-Debug": "1"},
+			want:    true,
+		},
+		{name: "header missing", match: MatchConfig{Prefix: "/", Headers: map[string]string{"X-Debug": ""}}, path: "/"},
+		{
+			name:    "header value",
+			match:   MatchConfig{Prefix: "/", Headers: map[string]string{"X-Env": "canary"}},
+			path:    "/",
+			headers: map[string]string{"X-Env": "canary"},
+			want:    true,
+		},
+		{
+			name:    "header value mismatch",
+			match:   MatchConfig{Prefix: "/", Headers: map[string]string{"X-Env": "canary"}},
+			path:    "/",
+			headers: map[string]string{"X-Env": "stable"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, err := NewRoute(&RouteConfig{Name: tt.name, Match: tt.match}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			if got := route.Matches(req); got != tt.want {
+				t.Errorf("Matches(%s %s) = %v, want %v", method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRouteInvalidMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		match MatchConfig
+	}{
+		{name: "no path", match: MatchConfig{Methods: []string{"GET"}}},
+		{name: "prefix and exact", match: MatchConfig{Prefix: "/", Exact: "/healthz"}},
+		{name: "exact and regex", match: MatchConfig{Exact: "/healthz", Regex: "^/"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRoute(&RouteConfig{Name: tt.name, Match: tt.match}, nil); !errors.Is(err, ErrInvalidMatch) {
+				t.Errorf("NewRoute() error = %v, want %v", err, ErrInvalidMatch)
+			}
+		})
+	}
+
+	if _, err := NewRoute(&RouteConfig{Name: "regex", Match: MatchConfig{Regex: "("}}, nil); err == nil {
+		t.Error("NewRoute() with an invalid regex succeeded")
+	}
+}
+
+// recordingLB records the requests it serves.
+type recordingLB struct {
+	requests []*http.Request
+}
+
+func (lb *recordingLB) ServeProxy(rw http.ResponseWriter, req *http.Request) {
+	lb.requests = append(lb.requests, req)
+}
+
+func TestRouterServeHTTP(t *testing.T) {
+	watch, api := &recordingLB{}, &recordingLB{}
+	watchRoute, err := NewRoute(&RouteConfig{
+		Name:        "watch",
+		Match:       MatchConfig{Exact: "/yorkie.v1.YorkieService/WatchDocument"},
+		StreamPaths: []string{"*"},
+	}, watch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiRoute, err := NewRoute(&RouteConfig{
+		Name:  "api",
+		Match: MatchConfig{Prefix: "/yorkie.v1.YorkieService/"},
+	}, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter([]*Route{watchRoute, apiRoute})
+
+	tests := []struct {
+		path       string
+		want       *recordingLB
+		wantStream bool
+		wantStatus int
+	}{
+		// the first matching route wins
+		{path: "/yorkie.v1.YorkieService/WatchDocument", want: watch, wantStream: true, wantStatus: http.StatusOK},
+		{path: "/yorkie.v1.YorkieService/PushPullChanges", want: api, wantStatus: http.StatusOK},
+		{path: "/whoami", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			watch.requests, api.requests = nil, nil
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			for _, lb := range []*recordingLB{watch, api} {
+				if served := len(lb.requests) == 1; served != (lb == tt.want) {
+					t.Fatalf("load balancer served %d requests, want served %v", len(lb.requests), lb == tt.want)
+				}
+			}
+			if tt.want != nil {
+				if got := stream.IsStream(tt.want.requests[0]); got != tt.wantStream {
+					t.Errorf("IsStream() = %v, want %v", got, tt.wantStream)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+)
+
+// withStreamIdleTimeout cancels the stream with backend.ErrStreamIdleTimeout
+// once neither its request body nor its response had traffic for the idle
+// timeout. It returns the response writer and request to serve the stream
+// with, and the function stopping the timer once the stream is served.
+func withStreamIdleTimeout(rw http.ResponseWriter, req *http.Request, idleTimeout time.Duration) (http.ResponseWriter, *http.Request, func()) {
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(idleTimeout, func() {
+		cancel(backend.ErrStreamIdleTimeout)
+	})
+	reset := func() {
+		timer.Reset(idleTimeout)
+	}
+
+	req = req.WithContext(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &idleReader{ReadCloser: req.Body, reset: reset}
+	}
+
+	return &idleWriter{ResponseWriter: rw, reset: reset}, req, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
+
+// idleReader resets the idle timer of a stream on every read of its request body.
+type idleReader struct {
+	io.ReadCloser
+	reset func()
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.reset()
+	}
+	return n, err
+}
+
+// idleWriter resets the idle timer of a stream on every write of its response.
+type idleWriter struct {
+	http.ResponseWriter
+	reset func()
+}
+
+func (w *idleWriter) WriteHeader(status int) {
+	w.reset()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idleWriter) Write(p []byte) (int, error) {
+	w.reset()
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher for streamed responses.
+func (w *idleWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the response writer of the client for http.ResponseController.
+func (w *idleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/krapie/l7/internal/grpc"
+)
+
+// VirtualHostConfig is the configuration of a virtual host, which serves the
+// requests of its domains with its own routes.
+type VirtualHostConfig struct {
+	Name string `mapstructure:"name"`
+	// Domains are the host names of the virtual host. A domain starting with
+	// "*." matches every subdomain, such as "*.example.com" for "a.example.com".
+	Domains []string      `mapstructure:"domains"`
+	Routes  []RouteConfig `mapstructure:"routes"`
+}
+
+// UnknownHostConfig is the configuration of requests whose host doesn't match
+// any virtual host.
+type UnknownHostConfig struct {
+	// Pool is the pool serving unknown hosts with the default load balancing policy.
+	Pool string `mapstructure:"pool"`
+	// Status is the status of the response to unknown hosts if no pool is
+	// configured, such as 421 Misdirected Request. It is 404 if zero.
+	Status int `mapstructure:"status"`
+}
+
+// VirtualHost is a set of domains served by their own router.
+type VirtualHost struct {
+	Name    string
+	Domains []string
+	Router  *Router
+}
+
+// HostRouter selects the router of a request by the virtual host matching its
+// Host header, or its TLS server name if the request has no Host header.
+type HostRouter struct {
+	exactHosts    map[string]*VirtualHost
+	wildcardHosts []wildcardHost
+
+	// defaultRouter serves unknown hosts, which get unknownStatus if it is nil.
+	defaultRouter *Router
+	unknownStatus int
+}
+
+type wildcardHost struct {
+	suffix string
+	host   *VirtualHost
+}
+
+// NewHostRouter creates a host router of the given virtual hosts. Requests of
+// unknown hosts are served by the default router if it is not nil, and get the
+// unknown status otherwise.
+func NewHostRouter(hosts []*VirtualHost, defaultRouter *Router, unknownStatus int) (*HostRouter, error) {
+	if unknownStatus == 0 {
+		unknownStatus = http.StatusNotFound
+	}
+
+	r := &HostRouter{
+		exactHosts:    make(map[string]*VirtualHost),
+		defaultRouter: defaultRouter,
+		unknownStatus: unknownStatus,
+	}
+
+	seen := make(map[string]string)
+	for _, host := range hosts {
+		for _, domain := range host.Domains {
+			domain = strings.ToLower(domain)
+			if other, ok := seen[domain]; ok {
+				return nil, fmt.Errorf("domain %s configured in virtual hosts %s and %s", domain, other, host.Name)
+			}
+			seen[domain] = host.Name
+
+			if strings.HasPrefix(domain, "*.") {
+				r.wildcardHosts = append(r.wildcardHosts, wildcardHost{
+					suffix: domain[1:],
+					host:   host,
+				})
+				continue
+			}
+			r.exactHosts[domain] = host
+		}
+	}
+
+	// the most specific wildcard wins
+	sort.SliceStable(r.wildcardHosts, func(i, j int) bool {
+		return len(r.wildcardHosts[i].suffix) > len(r.wildcardHosts[j].suffix)
+	})
+
+	return r, nil
+}
+
+// VirtualHost returns the virtual host matching the host name.
+func (r *HostRouter) VirtualHost(hostname string) (*VirtualHost, bool) {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if host, ok := r.exactHosts[hostname]; ok {
+		return host, true
+	}
+
+	for _, wildcard := range r.wildcardHosts {
+		if strings.HasSuffix(hostname, wildcard.suffix) {
+			return wildcard.host, true
+		}
+	}
+
+	return nil, false
+}
+
+func (r *HostRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if host, ok := r.VirtualHost(requestHost(req)); ok {
+		host.Router.ServeHTTP(rw, req)
+		return
+	}
+
+	if r.defaultRouter != nil {
+		r.defaultRouter.ServeHTTP(rw, req)
+		return
+	}
+
+	grpc.Error(rw, req, "[Router] Unknown host", r.unknownStatus)
+}
+
+// requestHost returns the host name of the request without port.
+func requestHost(req *http.Request) string {
+	host := req.Host
+	if host == "" && req.TLS != nil {
+		return req.TLS.ServerName
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
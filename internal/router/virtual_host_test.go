@@ -0,0 +1,116 @@
+package router
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostRouterVirtualHost(t *testing.T) {
+	api := &VirtualHost{Name: "api", Domains: []string{"api.yorkie.dev", "API.example.com"}}
+	yorkie := &VirtualHost{Name: "yorkie", Domains: []string{"*.yorkie.dev"}}
+	eu := &VirtualHost{Name: "eu", Domains: []string{"*.eu.yorkie.dev"}}
+
+	r, err := NewHostRouter([]*VirtualHost{yorkie, api, eu}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hostname string
+		want     *VirtualHost
+	}{
+		{hostname: "api.yorkie.dev", want: api},
+		{hostname: "api.example.com", want: api},
+		{hostname: "Api.Yorkie.Dev", want: api},
+		{hostname: "api.yorkie.dev.", want: api},
+		{hostname: "docs.yorkie.dev", want: yorkie},
+		{hostname: "a.b.yorkie.dev", want: yorkie},
+		// the most specific wildcard wins whatever the order of the hosts
+		{hostname: "api.eu.yorkie.dev", want: eu},
+		// wildcards only match subdomains
+		{hostname: "yorkie.dev"},
+		{hostname: "notyorkie.dev"},
+		{hostname: "whoami.local"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			got, ok := r.VirtualHost(tt.hostname)
+			if ok != (tt.want != nil) || got != tt.want {
+				t.Errorf("VirtualHost(%q) = %v, %v, want %v", tt.hostname, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHostRouterDuplicateDomain(t *testing.T) {
+	hosts := []*VirtualHost{
+		{Name: "a", Domains: []string{"*.yorkie.dev"}},
+		{Name: "b", Domains: []string{"*.Yorkie.dev"}},
+	}
+	if _, err := NewHostRouter(hosts, nil, 0); err == nil {
+		t.Error("NewHostRouter() with a domain in two virtual hosts succeeded")
+	}
+}
+
+func TestHostRouterServeHTTP(t *testing.T) {
+	newRouter := func(lb *recordingLB) *Router {
+		route, err := NewRoute(&RouteConfig{Name: "all", Match: MatchConfig{Prefix: "/"}}, lb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewRouter([]*Route{route})
+	}
+	hostLB, defaultLB := &recordingLB{}, &recordingLB{}
+	hosts := []*VirtualHost{{Name: "api", Domains: []string{"api.yorkie.dev"}, Router: newRouter(hostLB)}}
+
+	tests := []struct {
+		name          string
+		host          string
+		serverName    string
+		defaultRouter bool
+		unknownStatus int
+		want          *recordingLB
+		wantStatus    int
+	}{
+		{name: "host with port", host: "api.yorkie.dev:8080", want: hostLB, wantStatus: http.StatusOK},
+		{name: "TLS server name without host", serverName: "api.yorkie.dev", want: hostLB, wantStatus: http.StatusOK},
+		{name: "unknown host to default router", host: "whoami.local", defaultRouter: true, want: defaultLB, wantStatus: http.StatusOK},
+		{name: "unknown host", host: "whoami.local", wantStatus: http.StatusNotFound},
+		{name: "unknown host status", host: "whoami.local", unknownStatus: http.StatusMisdirectedRequest, wantStatus: http.StatusMisdirectedRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hostLB.requests, defaultLB.requests = nil, nil
+
+			var defaultRouter *Router
+			if tt.defaultRouter {
+				defaultRouter = newRouter(defaultLB)
+			}
+			r, err := NewHostRouter(hosts, defaultRouter, tt.unknownStatus)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			if tt.serverName != "" {
+				req.TLS = &tls.ConnectionState{ServerName: tt.serverName}
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for _, lb := range []*recordingLB{hostLB, defaultLB} {
+				if served := len(lb.requests) == 1; served != (lb == tt.want) {
+					t.Errorf("load balancer served %d requests, want served %v", len(lb.requests), lb == tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// MigrationConfig is the policy of moving streams to the new owner of their
+// key after a rebalance.
+type MigrationConfig struct {
+	// Rate is the maximum number of streams closed per second, unlimited if zero.
+	Rate float64 `mapstructure:"stream-migration-rate"`
+	// GracePeriod is the time between a rebalance and the closing of the
+	// streams it moved, during which further rebalances are coalesced, such as
+	// when several backends are added one after another.
+	GracePeriod time.Duration `mapstructure:"stream-migration-grace-period"`
+}
+
+// Migrator closes the streams of a load balancer which are no longer served by
+// the owner of their key, at a limited rate so that their clients don't
+// reconnect all at once.
+type Migrator struct {
+	registry    *Registry
+	limiter     *rate.Limiter
+	gracePeriod time.Duration
+
+	// misplaced returns whether the stream should move to another backend.
+	misplaced func(s *Stream) bool
+
+	rebalanceCh chan struct{}
+}
+
+func NewMigrator(registry *Registry, config MigrationConfig, misplaced func(s *Stream) bool) *Migrator {
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if config.Rate > 0 {
+		// allow a burst of one second worth of closes
+		limiter = rate.NewLimiter(rate.Limit(config.Rate), int(config.Rate)+1)
+	}
+
+	m := &Migrator{
+		registry:    registry,
+		limiter:     limiter,
+		gracePeriod: config.GracePeriod,
+
+		misplaced: misplaced,
+
+		rebalanceCh: make(chan struct{}, 1),
+	}
+	go m.run()
+
+	return m
+}
+
+// Rebalance schedules the migration of misplaced streams. It doesn't block.
+func (m *Migrator) Rebalance() {
+	select {
+	case m.rebalanceCh <- struct{}{}:
+	default:
+		// a migration is already scheduled
+	}
+}
+
+func (m *Migrator) run() {
+	for range m.rebalanceCh {
+		time.Sleep(m.gracePeriod)
+
+		// coalesce the rebalances of the grace period
+		select {
+		case <-m.rebalanceCh:
+		default:
+		}
+
+		m.migrate()
+	}
+}
+
+func (m *Migrator) migrate() {
+	var misplaced []*Stream
+	for _, s := range m.registry.Streams() {
+		if m.misplaced(s) {
+			misplaced = append(misplaced, s)
+		}
+	}
+	if len(misplaced) == 0 {
+		return
+	}
+
+	log.Printf("[LoadBalancer] Migrating %d streams", len(misplaced))
+	for _, s := range misplaced {
+		if err := m.limiter.Wait(context.Background()); err != nil {
+			log.Printf("[LoadBalancer] Error waiting for stream migration: %s", err)
+			return
+		}
+
+		// the stream may have ended or moved back while waiting
+		if !m.registry.Has(s) || !m.misplaced(s) {
+			continue
+		}
+
+		if err := s.Close(); err != nil {
+			log.Printf("[LoadBalancer] Error closing stream: %s", err)
+			continue
+		}
+		m.registry.Remove(s)
+	}
+}
@@ -0,0 +1,92 @@
+package stream
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMigratorMigrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        float64
+		streams     int
+		misplaced   func(s *Stream) bool
+		wantClosed  int
+		minDuration time.Duration
+	}{
+		{name: "nothing misplaced", streams: 10, misplaced: func(s *Stream) bool { return false }},
+		{name: "unlimited", streams: 100, misplaced: func(s *Stream) bool { return true }, wantClosed: 100},
+		{name: "only misplaced", streams: 10, misplaced: func(s *Stream) bool { return s.BackendID == "a" }, wantClosed: 5},
+		// a burst of 11 closes, then 10 more at 20 per second
+		{name: "rate limited", rate: 20, streams: 31, misplaced: func(s *Stream) bool { return true }, wantClosed: 31, minDuration: 450 * time.Millisecond},
+		{name: "burst within rate", rate: 20, streams: 21, misplaced: func(s *Stream) bool { return true }, wantClosed: 21},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			var closed int32
+			for i := 0; i < tt.streams; i++ {
+				backendID := "a"
+				if i%2 == 1 {
+					backendID = "b"
+				}
+				r.Add(New(httptest.NewRequest(http.MethodGet, "/", nil), backendID, func() error {
+					atomic.AddInt32(&closed, 1)
+					return nil
+				}))
+			}
+
+			m := NewMigrator(r, MigrationConfig{Rate: tt.rate}, tt.misplaced)
+			start := time.Now()
+			m.migrate()
+			elapsed := time.Since(start)
+
+			if got := int(atomic.LoadInt32(&closed)); got != tt.wantClosed {
+				t.Errorf("closed %d streams, want %d", got, tt.wantClosed)
+			}
+			if r.Len() != tt.streams-tt.wantClosed {
+				t.Errorf("%d streams tracked, want %d", r.Len(), tt.streams-tt.wantClosed)
+			}
+			if elapsed < tt.minDuration {
+				t.Errorf("migration took %s, want at least %s", elapsed, tt.minDuration)
+			}
+			if tt.minDuration == 0 && elapsed > 100*time.Millisecond {
+				t.Errorf("migration took %s, want no wait", elapsed)
+			}
+		})
+	}
+}
+
+func TestMigratorRebalance(t *testing.T) {
+	r := NewRegistry()
+	var closes int32
+	// the stream stays tracked when closing it fails, so that every migration
+	// tries to close it again
+	r.Add(New(httptest.NewRequest(http.MethodGet, "/", nil), "a", func() error {
+		atomic.AddInt32(&closes, 1)
+		return errors.New("close failed")
+	}))
+
+	m := NewMigrator(r, MigrationConfig{GracePeriod: 100 * time.Millisecond}, func(s *Stream) bool {
+		return true
+	})
+
+	// the rebalances of the grace period are coalesced into one migration
+	for i := 0; i < 5; i++ {
+		m.Rebalance()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&closes); got != 0 {
+		t.Fatalf("closed the stream %d times during the grace period", got)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if got := atomic.LoadInt32(&closes); got != 1 {
+		t.Errorf("closed the stream %d times, want once", got)
+	}
+}
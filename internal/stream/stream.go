@@ -0,0 +1,179 @@
+package stream
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/krapie/l7/internal/backend"
+)
+
+// Stream is a long-lived request being served by a backend, such as a
+// WatchDocument stream or an upgraded WebSocket connection.
+type Stream struct {
+	ID         uint64
+	BackendID  string
+	RemoteAddr string
+	Path       string
+	StartedAt  time.Time
+
+	// Owner is the load balancer serving the stream, and Key is the hash key
+	// of key-affinity load balancers, which move the stream when its key moves
+	// to another backend.
+	Owner interface{}
+	Key   string
+	// Spilled is true if the stream was not sent to the owner of its key
+	// because of bounded loads.
+	Spilled bool
+
+	close func() error
+}
+
+// New creates a stream of the request which is closed with the close function.
+func New(req *http.Request, backendID string, close func() error) *Stream {
+	return &Stream{
+		BackendID:  backendID,
+		RemoteAddr: req.RemoteAddr,
+		Path:       req.URL.Path,
+		StartedAt:  time.Now(),
+
+		close: close,
+	}
+}
+
+// Close terminates the stream, so that its client reconnects.
+func (s *Stream) Close() error {
+	return s.close()
+}
+
+// Registry tracks the streams of a backend pool. It is safe for concurrent use,
+// and tracks any number of streams per client.
+type Registry struct {
+	mutex     sync.RWMutex
+	nextID    uint64
+	streams   map[uint64]*Stream
+	byBackend map[string]map[uint64]*Stream
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		streams:   make(map[uint64]*Stream),
+		byBackend: make(map[string]map[uint64]*Stream),
+	}
+}
+
+// Add tracks the stream, and assigns its ID.
+func (r *Registry) Add(s *Stream) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.nextID++
+	s.ID = r.nextID
+
+	r.streams[s.ID] = s
+	streams, ok := r.byBackend[s.BackendID]
+	if !ok {
+		streams = make(map[uint64]*Stream)
+		r.byBackend[s.BackendID] = streams
+	}
+	streams[s.ID] = s
+}
+
+// Remove stops tracking the stream. It does nothing if the stream isn't tracked.
+func (r *Registry) Remove(s *Stream) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.streams[s.ID]; !ok {
+		return
+	}
+
+	delete(r.streams, s.ID)
+	streams := r.byBackend[s.BackendID]
+	delete(streams, s.ID)
+	if len(streams) == 0 {
+		delete(r.byBackend, s.BackendID)
+	}
+}
+
+// Has returns whether the stream is tracked.
+func (r *Registry) Has(s *Stream) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	_, ok := r.streams[s.ID]
+	return ok
+}
+
+// Streams returns the tracked streams.
+func (r *Registry) Streams() []*Stream {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	streams := make([]*Stream, 0, len(r.streams))
+	for _, s := range r.streams {
+		streams = append(streams, s)
+	}
+
+	return streams
+}
+
+// StreamsOf returns the streams served by the backend.
+func (r *Registry) StreamsOf(backendID string) []*Stream {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	streams := make([]*Stream, 0, len(r.byBackend[backendID]))
+	for _, s := range r.byBackend[backendID] {
+		streams = append(streams, s)
+	}
+
+	return streams
+}
+
+// Count returns the number of streams served by the backend.
+func (r *Registry) Count(backendID string) int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.byBackend[backendID])
+}
+
+// Counts returns the number of streams of each backend serving streams.
+func (r *Registry) Counts() map[string]int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	counts := make(map[string]int, len(r.byBackend))
+	for backendID, streams := range r.byBackend {
+		counts[backendID] = len(streams)
+	}
+
+	return counts
+}
+
+// Len returns the number of tracked streams.
+func (r *Registry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.streams)
+}
+
+// WithStream returns a context marking its request as a long-lived stream.
+func WithStream(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamKey{}, true)
+}
+
+// IsStream returns whether the request is a long-lived stream, either marked
+// by its route or an upgrade request.
+func IsStream(req *http.Request) bool {
+	if marked, _ := req.Context().Value(streamKey{}).(bool); marked {
+		return true
+	}
+
+	return backend.IsUpgrade(req)
+}
+
+type streamKey struct{}
@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestStream(backendID string) *Stream {
+	return New(httptest.NewRequest(http.MethodGet, "/", nil), backendID, func() error { return nil })
+}
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		name       string
+		backends   []string
+		remove     []int
+		wantCounts map[string]int
+	}{
+		{name: "empty", wantCounts: map[string]int{}},
+		{name: "one backend", backends: []string{"a", "a", "a"}, wantCounts: map[string]int{"a": 3}},
+		{name: "several backends", backends: []string{"a", "b", "a", "c"}, wantCounts: map[string]int{"a": 2, "b": 1, "c": 1}},
+		{name: "remove some", backends: []string{"a", "b", "a"}, remove: []int{0}, wantCounts: map[string]int{"a": 1, "b": 1}},
+		{name: "remove every stream of a backend", backends: []string{"a", "b"}, remove: []int{1}, wantCounts: map[string]int{"a": 1}},
+		{name: "remove twice", backends: []string{"a", "a"}, remove: []int{0, 0}, wantCounts: map[string]int{"a": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			var streams []*Stream
+			for _, backendID := range tt.backends {
+				s := newTestStream(backendID)
+				r.Add(s)
+				streams = append(streams, s)
+			}
+			removed := make(map[*Stream]bool)
+			for _, i := range tt.remove {
+				r.Remove(streams[i])
+				removed[streams[i]] = true
+			}
+
+			ids := make(map[uint64]bool)
+			for _, s := range streams {
+				if ids[s.ID] {
+					t.Errorf("ID %d assigned twice", s.ID)
+				}
+				ids[s.ID] = true
+
+				if r.Has(s) == removed[s] {
+					t.Errorf("Has(%d) = %v, want %v", s.ID, r.Has(s), !removed[s])
+				}
+			}
+
+			counts := r.Counts()
+			if len(counts) != len(tt.wantCounts) {
+				t.Errorf("Counts() = %v, want %v", counts, tt.wantCounts)
+			}
+			total := 0
+			for backendID, want := range tt.wantCounts {
+				if counts[backendID] != want || r.Count(backendID) != want || len(r.StreamsOf(backendID)) != want {
+					t.Errorf("streams of %s = %d, want %d", backendID, r.Count(backendID), want)
+				}
+				for _, s := range r.StreamsOf(backendID) {
+					if s.BackendID != backendID {
+						t.Errorf("StreamsOf(%s) has a stream of %s", backendID, s.BackendID)
+					}
+				}
+				total += want
+			}
+			if r.Len() != total || len(r.Streams()) != total {
+				t.Errorf("Len() = %d, want %d", r.Len(), total)
+			}
+		})
+	}
+}
+
+func TestIsStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		marked  bool
+		headers map[string]string
+		want    bool
+	}{
+		{name: "plain request", want: false},
+		{name: "marked by route", marked: true, want: true},
+		{name: "websocket upgrade", headers: map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, want: true},
+		{name: "upgrade header without connection", headers: map[string]string{"Upgrade": "websocket"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if tt.marked {
+				req = req.WithContext(WithStream(context.Background()))
+			}
+
+			if got := IsStream(req); got != tt.want {
+				t.Errorf("IsStream() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
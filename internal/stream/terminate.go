@@ -0,0 +1,104 @@
+package stream
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/krapie/l7/internal/grpc"
+)
+
+const (
+	// connectEndStreamFlag marks the end-stream message of a Connect stream.
+	connectEndStreamFlag = 0x02
+	// grpcWebTrailerFlag marks the trailer frame of a gRPC-Web response.
+	grpcWebTrailerFlag = 0x80
+
+	// gRPC-Web trailer names are lower-case
+	grpcWebStatusTrailer  = "grpc-status"
+	grpcWebMessageTrailer = "grpc-message"
+)
+
+var (
+	// ErrMoved is the cause of the cancellation of the streams closed because
+	// they moved to another backend.
+	ErrMoved = errors.New("stream moved to another backend, reconnect")
+)
+
+// Terminate ends the response of a stream whose proxying was aborted after the
+// response started, in a way its client recognizes as retryable: gRPC streams
+// end with UNAVAILABLE trailers, gRPC-Web streams with an UNAVAILABLE trailer
+// frame, and Connect streams with an unavailable end-stream message. It returns
+// false for other protocols, whose stream can only be reset.
+func Terminate(rw http.ResponseWriter, req *http.Request, message string) bool {
+	contentType := req.Header.Get("Content-Type")
+	switch {
+	// gRPC-Web clients can't read HTTP trailers, which are sent in the body instead
+	case strings.HasPrefix(contentType, "application/grpc-web"):
+		return writeGRPCWebTrailers(rw, contentType, message)
+	case grpc.IsGRPC(req):
+		header := rw.Header()
+		header.Set(http.TrailerPrefix+grpc.StatusHeader, strconv.Itoa(int(grpc.Unavailable)))
+		header.Set(http.TrailerPrefix+grpc.MessageHeader, grpc.EncodeMessage(message))
+		return true
+	case strings.HasPrefix(contentType, "application/connect+"):
+		return writeConnectEndStream(rw, message)
+	}
+
+	return false
+}
+
+// writeGRPCWebTrailers writes the trailer frame of a gRPC-Web response with the
+// UNAVAILABLE status. Text responses (application/grpc-web-text) are base64-encoded.
+func writeGRPCWebTrailers(rw http.ResponseWriter, contentType, message string) bool {
+	trailers := grpcWebStatusTrailer + ": " + strconv.Itoa(int(grpc.Unavailable)) + "\r\n" +
+		grpcWebMessageTrailer + ": " + grpc.EncodeMessage(message) + "\r\n"
+
+	frame := make([]byte, 5, 5+len(trailers))
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(trailers)))
+	frame = append(frame, trailers...)
+
+	if responseType := rw.Header().Get("Content-Type"); responseType != "" {
+		contentType = responseType
+	}
+	if strings.HasPrefix(contentType, "application/grpc-web-text") {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+	if _, err := rw.Write(frame); err != nil {
+		return false
+	}
+
+	return http.NewResponseController(rw).Flush() == nil
+}
+
+// writeConnectEndStream writes the end-stream message of a Connect stream with
+// an unavailable error.
+func writeConnectEndStream(rw http.ResponseWriter, message string) bool {
+	var endStream struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	endStream.Error.Code = "unavailable"
+	endStream.Error.Message = message
+
+	payload, err := json.Marshal(endStream)
+	if err != nil {
+		return false
+	}
+
+	envelope := make([]byte, 5, 5+len(payload))
+	envelope[0] = connectEndStreamFlag
+	binary.BigEndian.PutUint32(envelope[1:], uint32(len(payload)))
+	if _, err = rw.Write(append(envelope, payload...)); err != nil {
+		return false
+	}
+
+	return http.NewResponseController(rw).Flush() == nil
+}